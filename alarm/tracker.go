@@ -0,0 +1,158 @@
+package alarm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracker pairs the raise and clear events of alarms so that the time each alarm
+// was active can be determined. Alarms that are still open are persisted so that
+// an alarm raised in one run can be cleared in another.
+type Tracker struct {
+	filename string
+
+	mutex sync.Mutex
+	open  map[string]*openAlarm
+}
+
+type openAlarm struct {
+	Start time.Time         `json:"start"`
+	Tags  map[string]string `json:"tags"`
+
+	// cleared is set once the clear event has been seen and until the alarm is forgotten
+	cleared bool
+}
+
+// Duration represents an alarm that has been raised and since cleared
+type Duration struct {
+	Start time.Time
+	End   time.Time
+	Tags  map[string]string
+}
+
+// Load reads the open alarms from a file. A missing file results in no open alarms.
+func Load(filename string) (*Tracker, error) {
+	t := &Tracker{
+		filename: filename,
+		open:     make(map[string]*openAlarm),
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &t.open); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Save writes the open alarms back to the file
+func (t *Tracker) Save() error {
+	t.mutex.Lock()
+	data, err := json.Marshal(t.open)
+	t.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(t.filename, data, 0644)
+}
+
+func key(router string, id string) string {
+	return router + "/" + id
+}
+
+// Raise records that an alarm was raised. Seeing the same raise again, which happens
+// when a time window is queried twice, keeps the original start time.
+func (t *Tracker) Raise(router string, id string, start time.Time, tags map[string]string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if existing, ok := t.open[key(router, id)]; ok && !existing.cleared && !existing.Start.After(start) {
+		return
+	}
+
+	t.open[key(router, id)] = &openAlarm{Start: start, Tags: tags}
+}
+
+// Clear records that an alarm was cleared and returns how long it was active. False
+// is returned if the alarm was raised before tracking began. The alarm stays open, and
+// is saved as such, until Forget is called once its duration has been recorded.
+func (t *Tracker) Clear(router string, id string, end time.Time) (*Duration, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	alarm, ok := t.open[key(router, id)]
+	if !ok || alarm.cleared || end.Before(alarm.Start) {
+		return nil, false
+	}
+
+	alarm.cleared = true
+
+	return &Duration{
+		Start: alarm.Start,
+		End:   end,
+		Tags:  alarm.Tags,
+	}, true
+}
+
+// Forget removes a cleared alarm whose duration has been recorded. An alarm raised
+// again since the given start time is kept.
+func (t *Tracker) Forget(router string, id string, start time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if alarm, ok := t.open[key(router, id)]; ok && alarm.Start.Equal(start) {
+		delete(t.open, key(router, id))
+	}
+}
+
+// Expire forgets open alarms raised before the given time and returns how many were
+// forgotten. An alarm whose clear event was never seen would otherwise be held forever.
+func (t *Tracker) Expire(before time.Time) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	expired := 0
+	for k, alarm := range t.open {
+		if alarm.Start.Before(before) {
+			delete(t.open, k)
+			expired++
+		}
+	}
+
+	return expired
+}
+
+// Retain forgets the open alarms of a router, raised before the given time, that
+// aren't among the ids of its active alarms at that time, returning how many were
+// forgotten. Those alarms have since been cleared without their clear event being seen.
+func (t *Tracker) Retain(router string, ids []string, before time.Time) int {
+	active := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		active[key(router, id)] = true
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	forgotten := 0
+	for k, alarm := range t.open {
+		if strings.HasPrefix(k, router+"/") && !active[k] && alarm.Start.Before(before) {
+			delete(t.open, k)
+			forgotten++
+		}
+	}
+
+	return forgotten
+}
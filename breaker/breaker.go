@@ -0,0 +1,177 @@
+package breaker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Breaker tracks consecutive request failures per router. Once a router reaches the
+// failure threshold its circuit opens and requests for it are skipped for the rest of
+// the cycle. The next cycle is allowed a single probe request to decide whether the
+// circuit closes again.
+type Breaker struct {
+	threshold int
+
+	mutex   sync.Mutex
+	routers map[string]*routerState
+}
+
+type routerState struct {
+	Failures int  `json:"failures"`
+	Open     bool `json:"open"`
+
+	// whether this cycle's probe has been handed out and whether it's still in flight
+	probed  bool
+	probing bool
+}
+
+// State represents the circuit state of a single router
+type State struct {
+	Router   string
+	Open     bool
+	Failures int
+}
+
+// New creates a Breaker that opens after threshold consecutive failures
+func New(threshold int) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		routers:   make(map[string]*routerState),
+	}
+}
+
+// Load creates a Breaker from the state saved by a previous cycle. A missing file
+// results in every circuit starting closed.
+func Load(filename string, threshold int) (*Breaker, error) {
+	b := New(threshold)
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &b.routers); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Save persists the state of every circuit so that the next cycle can probe open routers.
+func (b *Breaker) Save(filename string) error {
+	b.mutex.Lock()
+	data, err := json.Marshal(b.routers)
+	b.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+func (b *Breaker) state(router string) *routerState {
+	state, ok := b.routers[router]
+	if !ok {
+		state = &routerState{}
+		b.routers[router] = state
+	}
+
+	return state
+}
+
+// Allow reports whether a request to the router should be made
+func (b *Breaker) Allow(router string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := b.state(router)
+	if !state.Open {
+		return true
+	}
+
+	if state.probed {
+		return false
+	}
+
+	state.probed = true
+	state.probing = true
+	return true
+}
+
+// Success records a successful request to the router and closes its circuit
+func (b *Breaker) Success(router string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := b.state(router)
+	state.Failures = 0
+	state.Open = false
+	state.probing = false
+}
+
+// Failure records a failed request to the router. It returns true if this failure
+// caused the circuit to open.
+func (b *Breaker) Failure(router string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := b.state(router)
+	state.Failures++
+
+	if state.probing {
+		state.probing = false
+		return false
+	}
+
+	if !state.Open && state.Failures >= b.threshold {
+		state.Open = true
+		state.probed = true
+		return true
+	}
+
+	return false
+}
+
+// NextCycle allows every open circuit another probe. Long running processes call it
+// at the start of each cycle.
+func (b *Breaker) NextCycle() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, state := range b.routers {
+		state.probed = false
+		state.probing = false
+	}
+}
+
+// IsOpen reports whether the router's circuit is open
+func (b *Breaker) IsOpen(router string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state(router).Open
+}
+
+// States returns the circuit state of every router seen by the breaker
+func (b *Breaker) States() []State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	states := make([]State, 0, len(b.routers))
+	for router, state := range b.routers {
+		states = append(states, State{
+			Router:   router,
+			Open:     state.Open,
+			Failures: state.Failures,
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Router < states[j].Router })
+	return states
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/128technology/influx-importer/client"
+	"github.com/128technology/influx-importer/logger"
+)
+
+// Cache stores the results of 128T discovery requests on disk so that every run
+// doesn't have to rediscover routers, metric metadata and permutations. Expired entries are
+// still served when the 128T fails to answer a discovery request. A nil Cache is
+// valid and simply fetches everything.
+type Cache struct {
+	filename       string
+	metadataTTL    time.Duration
+	permutationTTL time.Duration
+	refresh        bool
+
+	mutex sync.Mutex
+	data  cacheFile
+}
+
+type cacheFile struct {
+	Version      string                       `json:"version"`
+	Routers      *routersEntry                `json:"routers,omitempty"`
+	Metadata     *metadataEntry               `json:"metadata,omitempty"`
+	Permutations map[string]*permutationEntry `json:"permutations"`
+}
+
+type routersEntry struct {
+	Fetched time.Time       `json:"fetched"`
+	Routers []client.Router `json:"routers"`
+}
+
+type metadataEntry struct {
+	Fetched     time.Time                  `json:"fetched"`
+	Descriptors []*client.MetricDescriptor `json:"descriptors"`
+}
+
+type permutationEntry struct {
+	Fetched      time.Time                   `json:"fetched"`
+	Permutations []*client.MetricPermutation `json:"permutations"`
+}
+
+// Load reads the cache from a file. A missing file results in an empty cache.
+func Load(filename string, metadataTTL time.Duration, permutationTTL time.Duration) (*Cache, error) {
+	c := &Cache{
+		filename:       filename,
+		metadataTTL:    metadataTTL,
+		permutationTTL: permutationTTL,
+		data:           cacheFile{Permutations: make(map[string]*permutationEntry)},
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.data); err != nil {
+		return nil, err
+	}
+
+	if c.data.Permutations == nil {
+		c.data.Permutations = make(map[string]*permutationEntry)
+	}
+
+	return c, nil
+}
+
+// Save writes the cache back to its file
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	data, err := json.Marshal(c.data)
+	c.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.filename, data, 0644)
+}
+
+// Refresh forces every entry to be fetched again. Existing entries are still used
+// if fetching fails.
+func (c *Cache) Refresh() {
+	if c == nil {
+		return
+	}
+
+	c.refresh = true
+}
+
+// SetVersion invalidates the cache if the 128T software version has changed since
+// the cache was written
+func (c *Cache) SetVersion(version string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.data.Version == version {
+		return
+	}
+
+	if c.data.Version != "" {
+		logger.Log.Info("128T version changed from %v to %v. Invalidating the discovery cache.\n", c.data.Version, version)
+	}
+
+	c.data = cacheFile{
+		Version:      version,
+		Permutations: make(map[string]*permutationEntry),
+	}
+}
+
+func (c *Cache) expired(fetched time.Time, ttl time.Duration) bool {
+	return c.refresh || time.Since(fetched) > ttl
+}
+
+// Routers returns the routers of the 128T, calling fetch when the cached copy is
+// missing or expired. Routers come and go about as often as permutations do so they
+// share the permutation TTL.
+func (c *Cache) Routers(fetch func() ([]client.Router, error)) ([]client.Router, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	c.mutex.Lock()
+	entry := c.data.Routers
+	c.mutex.Unlock()
+
+	if entry != nil && !c.expired(entry.Fetched, c.permutationTTL) {
+		return entry.Routers, nil
+	}
+
+	routers, err := fetch()
+	if err != nil {
+		if entry == nil {
+			return nil, err
+		}
+
+		logger.Log.Warn("Unable to retrieve routers: %v. Using the copy cached at %v.\n",
+			err.Error(), entry.Fetched.Format(time.RFC3339))
+		return entry.Routers, nil
+	}
+
+	c.mutex.Lock()
+	c.data.Routers = &routersEntry{Fetched: time.Now(), Routers: routers}
+	c.mutex.Unlock()
+
+	return routers, nil
+}
+
+// Metadata returns the metric metadata, calling fetch when the cached copy is missing or expired
+func (c *Cache) Metadata(fetch func() ([]*client.MetricDescriptor, error)) ([]*client.MetricDescriptor, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	c.mutex.Lock()
+	entry := c.data.Metadata
+	c.mutex.Unlock()
+
+	if entry != nil && !c.expired(entry.Fetched, c.metadataTTL) {
+		return entry.Descriptors, nil
+	}
+
+	descriptors, err := fetch()
+	if err != nil {
+		if entry == nil {
+			return nil, err
+		}
+
+		logger.Log.Warn("Unable to retrieve metric metadata: %v. Using the copy cached at %v.\n",
+			err.Error(), entry.Fetched.Format(time.RFC3339))
+		return entry.Descriptors, nil
+	}
+
+	c.mutex.Lock()
+	c.data.Metadata = &metadataEntry{Fetched: time.Now(), Descriptors: descriptors}
+	c.mutex.Unlock()
+
+	return descriptors, nil
+}
+
+// Permutations returns the permutations of a metric on a router, calling fetch when
+// the cached copy is missing or expired
+func (c *Cache) Permutations(router string, metricID string, fetch func() ([]*client.MetricPermutation, error)) ([]*client.MetricPermutation, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	key := router + "/" + metricID
+
+	c.mutex.Lock()
+	entry := c.data.Permutations[key]
+	c.mutex.Unlock()
+
+	if entry != nil && !c.expired(entry.Fetched, c.permutationTTL) {
+		return entry.Permutations, nil
+	}
+
+	permutations, err := fetch()
+	if err != nil {
+		if entry == nil {
+			return nil, err
+		}
+
+		logger.Log.Warn("Unable to retrieve permutations for %v on router %v: %v. Using the copy cached at %v.\n",
+			metricID, router, err.Error(), entry.Fetched.Format(time.RFC3339))
+		return entry.Permutations, nil
+	}
+
+	c.mutex.Lock()
+	c.data.Permutations[key] = &permutationEntry{Fetched: time.Now(), Permutations: permutations}
+	c.mutex.Unlock()
+
+	return permutations, nil
+}
@@ -34,6 +34,12 @@ type AnalyticMetricRequest struct {
 	Filters   AnalyticMetricFilter `json:"filters"`
 }
 
+// MetricResult represents the outcome of a single request within a batch of metric requests
+type MetricResult struct {
+	Points []AnalyticPoint
+	Err    error
+}
+
 // ToString converts a filter map to a string for debug
 func (filter AnalyticMetricFilter) ToString() string {
 	var str []string
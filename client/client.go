@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,6 +18,31 @@ type Client struct {
 	httpClient *http.Client
 	baseURL    string
 	token      string
+	limiter    rateLimiter
+}
+
+// StatusError represents a response from the 128T with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e StatusError) Error() string {
+	return "Invalid status code: " + e.Status
+}
+
+// Unreachable determines whether an error means the 128T or the router behind it
+// couldn't be reached, as opposed to the request being refused or failing on its own.
+// Transport errors, timeouts and 5xx responses are the only such errors.
+func Unreachable(err error) bool {
+	switch e := err.(type) {
+	case *url.Error:
+		return true
+	case StatusError:
+		return e.StatusCode >= 500
+	}
+
+	return false
 }
 
 // create a default HTTP client
@@ -40,7 +66,7 @@ func CreateClient(baseURL string, token string) *Client {
 	}
 }
 
-func (client *Client) makeJSONRequest(url string, method string, requestBody interface{}, responseBody interface{}) (err error) {
+func (client *Client) makeJSONRequest(router string, url string, method string, requestBody interface{}, responseBody interface{}) (err error) {
 	var body []byte
 
 	if requestBody != nil {
@@ -55,6 +81,8 @@ func (client *Client) makeJSONRequest(url string, method string, requestBody int
 		return
 	}
 
+	client.limiter.wait(router)
+
 	req.Header.Set("Authorization", "Bearer "+client.token)
 	req.Header.Set("Content-Type", "application/json")
 
@@ -66,7 +94,7 @@ func (client *Client) makeJSONRequest(url string, method string, requestBody int
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err = errors.New("Invalid status code: " + resp.Status)
+		err = StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
 		return
 	}
 
@@ -79,15 +107,93 @@ func (client *Client) makeJSONRequest(url string, method string, requestBody int
 func (client *Client) GetMetric(router string, request *AnalyticMetricRequest) ([]AnalyticPoint, error) {
 	url := fmt.Sprintf("%v/api/v1/router/%v/metrics", client.baseURL, router)
 	var response []AnalyticPoint
-	err := client.makeJSONRequest(url, "POST", request, &response)
+	err := client.makeJSONRequest(router, url, "POST", request, &response)
 	return response, err
 }
 
+// GetMetrics retrieves multiple metrics for a router in a single GraphQL query. Each
+// request is given its own alias so the results are returned in the order requested.
+// Errors that only affect a single request are reported within its result. The query
+// shape isn't part of a documented 128T schema so this is experimental.
+func (client *Client) GetMetrics(router string, requests []*AnalyticMetricRequest) ([]MetricResult, error) {
+	var query bytes.Buffer
+	query.WriteString("{ metrics {")
+
+	for i, request := range requests {
+		filters := make([]string, 0, len(request.Filters))
+		for k, v := range request.Filters {
+			filters = append(filters, fmt.Sprintf("{name: %v, value: %v}", graphQLString(k), graphQLString(v)))
+		}
+
+		fmt.Fprintf(&query, " m%v: value(router: %v, id: %v, transform: %v, window: {start: %v, end: %v}, filters: [%v]) { value date }",
+			i,
+			graphQLString(router),
+			graphQLString(request.ID),
+			graphQLString(request.Transform),
+			graphQLString(request.Window.Start),
+			graphQLString(request.Window.End),
+			strings.Join(filters, ", "))
+	}
+
+	query.WriteString(" } }")
+
+	var response struct {
+		Data struct {
+			Metrics map[string][]AnalyticPoint `json:"metrics"`
+		} `json:"data"`
+		Errors []struct {
+			Message string        `json:"message"`
+			Path    []interface{} `json:"path"`
+		} `json:"errors"`
+	}
+
+	url := fmt.Sprintf("%v/api/v1/graphql", client.baseURL)
+	body := map[string]interface{}{"query": query.String()}
+	if err := client.makeJSONRequest(router, url, "POST", body, &response); err != nil {
+		return nil, err
+	}
+
+	results := make([]MetricResult, len(requests))
+	for _, e := range response.Errors {
+		// Errors without a path to an alias apply to the entire query
+		if len(e.Path) < 2 {
+			return nil, fmt.Errorf("%v", e.Message)
+		}
+
+		var index int
+		if alias, ok := e.Path[1].(string); ok {
+			if _, err := fmt.Sscanf(alias, "m%d", &index); err == nil && index < len(results) {
+				results[index].Err = errors.New(e.Message)
+			}
+		}
+	}
+
+	for i := range results {
+		results[i].Points = response.Data.Metrics[fmt.Sprintf("m%v", i)]
+	}
+
+	return results, nil
+}
+
+// graphQLString quotes a string for use as a GraphQL argument
+func graphQLString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
 // GetRouters retrieves a list of the routers
 func (client *Client) GetRouters() ([]Router, error) {
 	url := fmt.Sprintf("%v/api/v1/router", client.baseURL)
 	var response []Router
-	err := client.makeJSONRequest(url, "GET", nil, &response)
+	err := client.makeJSONRequest("", url, "GET", nil, &response)
+	return response, err
+}
+
+// GetNodes retrieves the nodes of a router
+func (client *Client) GetNodes(router string) ([]Node, error) {
+	url := fmt.Sprintf("%v/api/v1/router/%v/node", client.baseURL, router)
+	var response []Node
+	err := client.makeJSONRequest(router, url, "GET", nil, &response)
 	return response, err
 }
 
@@ -95,7 +201,7 @@ func (client *Client) GetRouters() ([]Router, error) {
 func (client *Client) GetSystemInfo() (SystemInformation, error) {
 	url := fmt.Sprintf("%v/api/v1/system", client.baseURL)
 	var response SystemInformation
-	err := client.makeJSONRequest(url, "GET", nil, &response)
+	err := client.makeJSONRequest("", url, "GET", nil, &response)
 	return response, err
 }
 
@@ -103,24 +209,29 @@ func (client *Client) GetSystemInfo() (SystemInformation, error) {
 func (client *Client) GetAlarms(router string) ([]Alarm, error) {
 	url := fmt.Sprintf("%v/api/v1/router/%v/alarms", client.baseURL, router)
 	var response []Alarm
-	err := client.makeJSONRequest(url, "GET", nil, &response)
+	err := client.makeJSONRequest(router, url, "GET", nil, &response)
 	return response, err
 }
 
-// GetAuditEvents retrieves the historical audit events for a router
-func (client *Client) GetAuditEvents(router string, filter []string, startTime time.Time, endTime time.Time) ([]AuditEvent, error) {
+// GetAuditEvents retrieves the historical audit events for a router. A limit greater
+// than 0 caps the number of events returned, starting with the oldest.
+func (client *Client) GetAuditEvents(router string, filter []string, startTime time.Time, endTime time.Time, limit int) ([]AuditEvent, error) {
 	values := make(url.Values)
 	values.Add("router", router)
 	values.Add("start", startTime.UTC().Format(time.RFC3339))
 	values.Add("end", endTime.UTC().Format(time.RFC3339))
 
+	if limit > 0 {
+		values.Add("limit", strconv.Itoa(limit))
+	}
+
 	for _, v := range filter {
 		values.Add("filter", v)
 	}
 
 	url := fmt.Sprintf("%v/api/v1/audit?%v", client.baseURL, values.Encode())
 	var response []AuditEvent
-	err := client.makeJSONRequest(url, "GET", nil, &response)
+	err := client.makeJSONRequest(router, url, "GET", nil, &response)
 	return response, err
 }
 
@@ -147,6 +258,7 @@ func (client *Client) GetMetricMetadata() ([]*MetricDescriptor, error) {
 				Metadata []struct {
 					ID          string   `json:"id"`
 					Description string   `json:"description"`
+					Units       string   `json:"units"`
 					Arguments   []string `json:"arguments"`
 				} `json:"metadata"`
 			} `json:"metrics"`
@@ -158,7 +270,7 @@ func (client *Client) GetMetricMetadata() ([]*MetricDescriptor, error) {
 
 	var descriptors []*MetricDescriptor
 	url := fmt.Sprintf("%v/api/v1/graphql", client.baseURL)
-	err := client.makeJSONRequest(url, "GET", body, &response)
+	err := client.makeJSONRequest("", url, "GET", body, &response)
 	if err != nil {
 		return descriptors, err
 	}
@@ -173,6 +285,7 @@ func (client *Client) GetMetricMetadata() ([]*MetricDescriptor, error) {
 		descriptors = append(descriptors, &MetricDescriptor{
 			ID:          m.ID,
 			Description: m.Description,
+			Units:       m.Units,
 			Keys:        m.Arguments,
 		})
 	}
@@ -218,7 +331,7 @@ func (client *Client) GetMetricPermutations(router string, descriptor MetricDesc
 		Parameters: params,
 	}
 
-	err := client.makeJSONRequest(url, "POST", body, &response)
+	err := client.makeJSONRequest(router, url, "POST", body, &response)
 	if err != nil {
 		return permutations, err
 	}
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"regexp"
 	"strconv"
 	"time"
@@ -15,44 +17,127 @@ type Router struct {
 	Location string `json:"locationCoordinates"`
 }
 
+// Node represents a node of a 128T Router
+type Node struct {
+	Name            string `json:"name"`
+	Role            string `json:"role"`
+	SoftwareVersion string `json:"softwareVersion"`
+}
+
 // Alarm represents an alarm event object.
 type Alarm map[string]interface{}
 
+// AlarmSeverities lists the severities an alarm can be raised with.
+var AlarmSeverities = []string{"CRITICAL", "MAJOR", "MINOR", "INFO"}
+
+// Get retrieves the value of an alarm property as a string
+func (a Alarm) Get(key string) string {
+	value, ok := a[key]
+	if !ok || value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Hash computes a hash of the alarm's properties, including its id, so that two alarms
+// can be told apart however similar they are
+func (a Alarm) Hash() uint64 {
+	// Map keys are marshalled in sorted order so the encoding is stable
+	identity, _ := json.Marshal(a)
+
+	hash := fnv.New64a()
+	hash.Write(identity)
+	return hash.Sum64()
+}
+
+// The sub-types of ALARM audit events
+const (
+	AlarmRaised  = "ADD"
+	AlarmCleared = "CLEAR"
+)
+
 // AuditEvent represents an event object.
 type AuditEvent struct {
 	Type      string                 `json:"type"`
+	SubType   string                 `json:"subType"`
 	Timestamp time.Time              `json:"timestamp"`
 	Router    string                 `json:"router"`
 	Node      string                 `json:"node"`
 	Data      map[string]interface{} `json:"data"`
 }
 
+// AlarmID retrieves the identifier of the alarm an ALARM audit event refers to
+func (e AuditEvent) AlarmID() string {
+	id, ok := e.Data["id"]
+	if !ok || id == nil {
+		return ""
+	}
+
+	return fmt.Sprint(id)
+}
+
+// Hash computes a hash of everything that identifies the event so that the same event
+// retrieved twice always hashes to the same value.
+func (e AuditEvent) Hash() uint64 {
+	// Map keys are marshalled in sorted order so the encoding is stable
+	identity, _ := json.Marshal(e)
+
+	hash := fnv.New64a()
+	hash.Write(identity)
+	return hash.Sum64()
+}
+
 // SystemInformation represents information about the connected 128T server.
 type SystemInformation struct {
 	Version string `json:"softwareVersion"`
 }
 
-// LocationGeohash converts the ISO location field into a geohash
-func (a Router) LocationGeohash() (string, error) {
-	if a.Location == "" {
-		return "", nil
-	}
-
+// Coordinates converts the ISO location field into a latitude and longitude
+func (a Router) Coordinates() (float64, float64, error) {
 	ISOCoord := regexp.MustCompile(`(\+|-)\d+\.?\d*`)
 	temp := ISOCoord.FindAllString(a.Location, 2)
 	if len(temp) < 2 {
-		return "", fmt.Errorf("location is in incorrect format")
+		return 0, 0, fmt.Errorf("location is in incorrect format")
 	}
 
 	lat, err := strconv.ParseFloat(temp[0], 64)
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
 
 	lon, err := strconv.ParseFloat(temp[1], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return lat, lon, nil
+}
+
+// LocationGeohash converts the ISO location field into a geohash
+func (a Router) LocationGeohash() (string, error) {
+	if a.Location == "" {
+		return "", nil
+	}
+
+	lat, lon, err := a.Coordinates()
 	if err != nil {
 		return "", err
 	}
 
 	return geohash.Encode(lat, lon), nil
 }
+
+// LocationGeohashWithPrecision converts the ISO location field into a geohash of the given number of characters
+func (a Router) LocationGeohashWithPrecision(precision uint) (string, error) {
+	if a.Location == "" {
+		return "", nil
+	}
+
+	lat, lon, err := a.Coordinates()
+	if err != nil {
+		return "", err
+	}
+
+	return geohash.EncodeWithPrecision(lat, lon, precision), nil
+}
@@ -4,6 +4,7 @@ package client
 type MetricDescriptor struct {
 	ID          string
 	Description string
+	Units       string
 	Keys        []string
 }
 
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a simple token bucket rate limiter. Callers that find the bucket
+// empty borrow against future tokens and sleep until their token would have been
+// available, which keeps waiters in roughly the order they arrived.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(requestsPerSecond float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   requestsPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available
+func (b *tokenBucket) wait() {
+	b.mutex.Lock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+
+	b.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// rateLimiter applies a global token bucket and, optionally, a token bucket per router
+type rateLimiter struct {
+	global *tokenBucket
+
+	mutex       sync.Mutex
+	routerRate  float64
+	routerBurst int
+	routers     map[string]*tokenBucket
+}
+
+func (l *rateLimiter) routerBucket(router string) *tokenBucket {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.routerRate <= 0 {
+		return nil
+	}
+
+	bucket, ok := l.routers[router]
+	if !ok {
+		bucket = newTokenBucket(l.routerRate, l.routerBurst)
+		l.routers[router] = bucket
+	}
+
+	return bucket
+}
+
+// wait blocks until a request to the given router is allowed. Requests that aren't
+// tied to a router should pass an empty string.
+func (l *rateLimiter) wait(router string) {
+	if router != "" {
+		if bucket := l.routerBucket(router); bucket != nil {
+			bucket.wait()
+		}
+	}
+
+	if l.global != nil {
+		l.global.wait()
+	}
+}
+
+// LimitRate bounds the number of requests per second the client makes to the 128T.
+// A requestsPerSecond of 0 or less removes the limit.
+func (client *Client) LimitRate(requestsPerSecond float64, burst int) {
+	if requestsPerSecond <= 0 {
+		client.limiter.global = nil
+		return
+	}
+
+	client.limiter.global = newTokenBucket(requestsPerSecond, burst)
+}
+
+// LimitRouterRate bounds the number of requests per second the client makes for any
+// single router. A requestsPerSecond of 0 or less removes the limit.
+func (client *Client) LimitRouterRate(requestsPerSecond float64, burst int) {
+	client.limiter.mutex.Lock()
+	defer client.limiter.mutex.Unlock()
+
+	client.limiter.routerRate = requestsPerSecond
+	client.limiter.routerBurst = burst
+	client.limiter.routers = make(map[string]*tokenBucket)
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"math"
+	"net/http"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -12,16 +16,37 @@ import (
 	"github.com/abiosoft/semaphore"
 	"github.com/howeyc/gopass"
 
+	"github.com/128technology/influx-importer/alarm"
+	"github.com/128technology/influx-importer/breaker"
+	"github.com/128technology/influx-importer/cache"
 	t128 "github.com/128technology/influx-importer/client"
 	"github.com/128technology/influx-importer/config"
+	"github.com/128technology/influx-importer/event"
+	"github.com/128technology/influx-importer/grafana"
+	"github.com/128technology/influx-importer/graphite"
 	"github.com/128technology/influx-importer/influx"
 	"github.com/128technology/influx-importer/logger"
+	"github.com/128technology/influx-importer/pool"
+	"github.com/128technology/influx-importer/prometheus"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var build = "development"
 
-const alarmHistorySeriesName = "alarm-history"
+const alarmDurationSeriesName = "alarm-duration"
+const circuitBreakerSeriesName = "circuit-breaker"
+const metadataSeriesName = "influx_importer_metadata"
+const inventorySeriesName = "inventory"
+const activeAlarmsSeriesName = "active-alarms"
+const activeAlarmCountSeriesName = "active-alarm-count"
+
+// activeAlarmTags are the alarm properties that are written as tags rather than fields
+var activeAlarmTags = []string{"node", "severity", "category", "source"}
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// invalidUnitsChars matches the runs of characters in units that can't appear in a field name
+var invalidUnitsChars = regexp.MustCompile(`[^a-z0-9]+`)
 
 var (
 	app = kingpin.New("influx-importer", "An application for extracting 128T metrics and loading them into Influx")
@@ -31,48 +56,363 @@ var (
 
 	extractCommand = app.Command("extract", "Extract metrics from a 128T instance and load them into Influx")
 	configFile     = extractCommand.Flag("config", "The configuration filename.").Required().String()
+	refreshCache   = extractCommand.Flag("refresh-cache", "Fetch routers, metric metadata and permutations again rather than using the cache.").Bool()
+
+	exporterCommand    = app.Command("exporter", "Periodically fetch metrics from a 128T instance and serve their latest values for Prometheus to scrape")
+	exporterConfigFile = exporterCommand.Flag("config", "The configuration filename.").Required().String()
+
+	setupCommand    = app.Command("influx-setup", "Create the Influx databases, retention policies and continuous queries used by the configuration")
+	setupConfigFile = setupCommand.Flag("config", "The configuration filename.").Required().String()
 )
 
 type extractor struct {
 	config       *config.Config
+	target       config.TargetConfig
 	client       *t128.Client
 	influxClient *influx.Client
+	breaker      *breaker.Breaker
+	cache        *cache.Cache
+	alarms       *alarm.Tracker
+	grafana      *grafana.Client
+	prometheus   *prometheus.Client
+	graphite     *graphite.Client
+	tags         *influx.TagMapper
+
+	// registry holds the latest metric values when running as an exporter, in which
+	// case nothing is written to Influx
+	registry *prometheus.Registry
 }
 
-func createExtractor() (*extractor, error) {
-	cfg, err := config.Load(*configFile)
-	if err != nil {
-		return nil, err
+// createExtractors creates an extractor for every target. Passing a registry creates
+// extractors which record the latest metric values in it rather than writing to Influx.
+func createExtractors(cfg *config.Config, registry *prometheus.Registry) ([]*extractor, error) {
+	extractors := make([]*extractor, 0, len(cfg.Targets))
+	for _, target := range cfg.Targets {
+		ext, err := createExtractor(cfg, target, registry)
+		if err != nil {
+			if target.Name != "" {
+				return nil, fmt.Errorf("target %v: %v", target.Name, err)
+			}
+			return nil, err
+		}
+
+		extractors = append(extractors, ext)
 	}
 
-	client := t128.CreateClient(cfg.Target.URL, cfg.Target.Token)
+	return extractors, nil
+}
 
-	influxClient, err := influx.CreateClient(cfg.Influx.Address, cfg.Influx.Database, cfg.Influx.Username, cfg.Influx.Password)
-	if err != nil {
-		return nil, err
+// targetFilename determines the name of a file holding state for a target. Each named
+// target keeps its own state alongside the configured filename.
+func targetFilename(filename string, target config.TargetConfig) string {
+	if filename == "" || target.Name == "" {
+		return filename
+	}
+
+	return filename + "." + target.Name
+}
+
+func createExtractor(cfg *config.Config, target config.TargetConfig, registry *prometheus.Registry) (*extractor, error) {
+	client := t128.CreateClient(target.URL, target.Token)
+	client.LimitRate(target.RequestsPerSecond, target.RequestBurst)
+	client.LimitRouterRate(target.RouterRequestsPerSecond, target.RouterRequestBurst)
+
+	var influxClient *influx.Client
+	var err error
+	if registry == nil {
+		influxClient, err = createInfluxClient(cfg, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var circuitBreaker *breaker.Breaker
+	if cfg.CircuitBreaker.Enabled {
+		if cfg.CircuitBreaker.StateFile != "" {
+			circuitBreaker, err = breaker.Load(targetFilename(cfg.CircuitBreaker.StateFile, target), cfg.CircuitBreaker.FailureThreshold)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load circuit breaker state: %v", err)
+			}
+		} else {
+			circuitBreaker = breaker.New(cfg.CircuitBreaker.FailureThreshold)
+		}
+	}
+
+	var discoveryCache *cache.Cache
+	if cfg.Cache.Enabled {
+		discoveryCache, err = cache.Load(targetFilename(cfg.Cache.File, target),
+			time.Duration(cfg.Cache.MetadataTTL)*time.Second,
+			time.Duration(cfg.Cache.PermutationTTL)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load cache: %v", err)
+		}
+
+		if *refreshCache {
+			discoveryCache.Refresh()
+		}
+	}
+
+	var alarmTracker *alarm.Tracker
+	if cfg.Events.Enabled && cfg.Events.AlarmDurationStateFile != "" {
+		alarmTracker, err = alarm.Load(targetFilename(cfg.Events.AlarmDurationStateFile, target))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load open alarms: %v", err)
+		}
+	}
+
+	var grafanaClient *grafana.Client
+	if cfg.Grafana.Enabled {
+		grafanaClient = grafana.CreateClient(cfg.Grafana.URL, cfg.Grafana.APIKey)
+	}
+
+	var prometheusClient *prometheus.Client
+	if cfg.Prometheus.Enabled {
+		prometheusClient = prometheus.CreateClient(cfg.Prometheus.URL, cfg.Prometheus.MetricPrefix,
+			cfg.Prometheus.Retries, time.Duration(cfg.Prometheus.Timeout)*time.Second)
+		prometheusClient.Authenticate(cfg.Prometheus.Username, cfg.Prometheus.Password,
+			cfg.Prometheus.BearerToken, cfg.Prometheus.Tenant)
+	}
+
+	var graphiteClient *graphite.Client
+	if cfg.Graphite.Enabled {
+		template, err := graphite.ParseTemplate(cfg.Graphite.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Graphite template: %v", err)
+		}
+		graphiteClient = graphite.CreateClient(cfg.Graphite.Address, cfg.Graphite.Protocol, template,
+			cfg.Graphite.BatchSize, cfg.Graphite.BufferSize, time.Duration(cfg.Graphite.Timeout)*time.Second)
 	}
 
 	return &extractor{
 		client:       client,
 		influxClient: influxClient,
 		config:       cfg,
+		target:       target,
+		breaker:      circuitBreaker,
+		cache:        discoveryCache,
+		alarms:       alarmTracker,
+		grafana:      grafanaClient,
+		prometheus:   prometheusClient,
+		graphite:     graphiteClient,
+		tags:         createTagMapper(cfg.Tags, target),
+		registry:     registry,
 	}, nil
 }
 
-func (e *extractor) extractAndSend(routerName string, metricID string, filter t128.AnalyticMetricFilter) {
-	paramStr := filter.ToString()
+// createInfluxClient creates the Influx client a target's data is written with
+func createInfluxClient(cfg *config.Config, target config.TargetConfig) (*influx.Client, error) {
+	if cfg.Influx.Address == "" {
+		return nil, fmt.Errorf("you must have an [influx] section set in the configuration file")
+	}
 
-	window := t128.AnalyticWindow{End: "now"}
+	naming := &influx.Naming{
+		Prefix:          cfg.Influx.MeasurementPrefix,
+		WideMeasurement: cfg.Influx.WideMeasurement,
+	}
+	if cfg.Influx.MeasurementTemplate != "" {
+		template, err := influx.ParseMeasurementTemplate(cfg.Influx.MeasurementTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Influx measurement-template: %v", err)
+		}
+		naming.Template = template
+	}
+
+	database := cfg.Influx.Database
+	if target.Database != "" {
+		database = target.Database
+	}
+
+	var influxClient *influx.Client
+	var err error
+	if cfg.Influx.Protocol == config.ProtocolUDP {
+		// Nothing is queried further back than the longest max-query-time
+		maxAge := time.Duration(cfg.Metrics.QueryTime) * time.Second
+		if eventsMaxAge := time.Duration(cfg.Events.QueryTime) * time.Second; eventsMaxAge > maxAge {
+			maxAge = eventsMaxAge
+		}
+
+		influxClient, err = influx.CreateUDPClient(cfg.Influx.Address, cfg.Influx.UDPPayloadSize, database,
+			targetFilename(cfg.Influx.WatermarkFile, target), maxAge)
+	} else {
+		influxClient, err = influx.CreateClient(cfg.Influx.Address, database, cfg.Influx.Username, cfg.Influx.Password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = influxClient.ConfigureWrites(influx.WriteOptions{
+		BatchSize:       cfg.Influx.BatchSize,
+		FlushInterval:   time.Duration(cfg.Influx.FlushInterval) * time.Millisecond,
+		BufferSize:      cfg.Influx.BufferSize,
+		MetricPrecision: cfg.Influx.MetricPrecision,
+		SeriesPrecision: cfg.Influx.SeriesPrecision,
+		Gzip:            cfg.Influx.Gzip,
+		Consistency:     cfg.Influx.WriteConsistency,
+		Timeout:         time.Duration(cfg.Influx.WriteTimeout) * time.Millisecond,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	influxClient.MapTags(createTagMapper(cfg.Tags, target))
+
+	influxClient.NameMeasurements(naming)
+
+	routes := make([]influx.Route, len(cfg.Routes))
+	for i, route := range cfg.Routes {
+		routes[i] = influx.Route{
+			Metrics:         route.Metrics,
+			Series:          route.Series,
+			Routers:         route.Routers,
+			Database:        route.Database,
+			RetentionPolicy: route.RetentionPolicy,
+		}
+	}
+	influxClient.Route(routes)
+
+	return influxClient, nil
+}
+
+func createTagMapper(cfg config.TagsConfig, target config.TargetConfig) *influx.TagMapper {
+	mapper := &influx.TagMapper{
+		Rename: cfg.Rename,
+		Drop:   cfg.Drop,
+		Static: make(map[string]string, len(cfg.Static)+1),
+	}
+
+	for k, v := range cfg.Static {
+		mapper.Static[k] = v
+	}
+
+	// Data from named targets is tagged so targets sharing a database can be told apart
+	if target.Name != "" {
+		mapper.Static["target"] = target.Name
+	}
+
+	for _, rewrite := range cfg.Rewrites {
+		mapper.Rewrites = append(mapper.Rewrites, influx.TagRewrite{
+			Key:         rewrite.Key,
+			Pattern:     rewrite.Pattern,
+			Replacement: rewrite.Replacement,
+		})
+	}
 
-	lastRecordedTime, err := e.influxClient.LastRecordedTime(metricID, filter)
+	return mapper
+}
+
+// request makes a 128T request for a router through the router's circuit breaker. Only
+// errors meaning the router is unreachable count towards opening its circuit. Others,
+// such as a metric the router doesn't support, leave the count as it was.
+func (e *extractor) request(router string, fn func() error) error {
+	if e.breaker == nil {
+		return fn()
+	}
+
+	if !e.breaker.Allow(router) {
+		return errCircuitOpen
+	}
+
+	err := fn()
+	if err == nil {
+		e.breaker.Success(router)
+	} else if t128.Unreachable(err) && e.breaker.Failure(router) {
+		logger.Log.Warn("Circuit breaker opened for router %v after %v consecutive failures. Skipping it for the rest of this run.\n",
+			router, e.config.CircuitBreaker.FailureThreshold)
+	}
+
+	return err
+}
+
+// circuitOpen reports whether requests for the router are being skipped for the rest of the run
+func (e *extractor) circuitOpen(router string) bool {
+	return e.breaker != nil && e.breaker.IsOpen(router)
+}
+
+// probe makes a single request to a router whose circuit was left open by a previous
+// run to determine whether it should be queried during this run
+func (e *extractor) probe(router string) bool {
+	if !e.circuitOpen(router) {
+		return true
+	}
+
+	err := e.request(router, func() error {
+		_, err := e.client.GetAlarms(router)
+		return err
+	})
 	if err != nil {
-		logger.Log.Warn("requesting last recorded time for %v: %s. Defaulting to last %v seconds\n",
-			metricID, err.Error(), e.config.Metrics.QueryTime)
-		lastRecordedTime = &time.Time{}
+		logger.Log.Warn("Circuit breaker for router %v is open and its probe failed: %v. Skipping it.\n", router, err.Error())
+		return false
 	}
 
-	endTime := int32(math.Min(float64(e.config.Metrics.QueryTime), time.Since(*lastRecordedTime).Seconds()))
-	window.Start = fmt.Sprintf("now-%v", endTime)
+	logger.Log.Info("Circuit breaker for router %v closed after a successful probe.\n", router)
+	return true
+}
+
+// recordCircuitBreakers writes the state of every router's circuit to Influx and
+// persists it for the next run
+func (e *extractor) recordCircuitBreakers() {
+	if e.breaker == nil {
+		return
+	}
+
+	if e.config.CircuitBreaker.StateFile != "" {
+		if err := e.breaker.Save(targetFilename(e.config.CircuitBreaker.StateFile, e.target)); err != nil {
+			logger.Log.Error("Unable to save circuit breaker state: %v\n", err.Error())
+		}
+	}
+
+	if e.influxClient == nil {
+		return
+	}
+
+	now := time.Now()
+	states := e.breaker.States()
+	records := make([]influx.Record, len(states))
+	for i, state := range states {
+		records[i] = influx.Record{
+			Time: now,
+			Tags: map[string]string{"router": state.Router},
+			Fields: map[string]interface{}{
+				"open":     state.Open,
+				"failures": state.Failures,
+			},
+		}
+	}
+
+	if len(records) != 0 {
+		if err := e.influxClient.Insert(circuitBreakerSeriesName, records); err != nil {
+			logger.Log.Error("Influx write for %v failed: %v\n", circuitBreakerSeriesName, err.Error())
+		}
+	}
+}
+
+// metricQuery represents a request for a single metric permutation on a router
+type metricQuery struct {
+	metricJob
+	duration int32
+	request  *t128.AnalyticMetricRequest
+}
+
+func (e *extractor) createMetricQuery(job metricJob) *metricQuery {
+	metricID := job.metricID
+	filter := job.filter
+	window := t128.AnalyticWindow{End: "now"}
+
+	var duration int32
+	if e.registry != nil {
+		// Only the latest value is exported so there's no need to catch up on history
+		duration = int32(e.config.Exporter.Lookback)
+	} else {
+		lastRecordedTime, err := e.influxClient.LastRecordedTime(metricID, filter)
+		if err != nil {
+			logger.Log.Warn("requesting last recorded time for %v: %s. Defaulting to last %v seconds\n",
+				metricID, err.Error(), e.config.Metrics.QueryTime)
+			lastRecordedTime = &time.Time{}
+		}
+
+		duration = int32(math.Min(float64(e.config.Metrics.QueryTime), time.Since(*lastRecordedTime).Seconds()))
+	}
+	window.Start = fmt.Sprintf("now-%v", duration)
 
 	routerlessFilter := make(t128.AnalyticMetricFilter)
 	for k := range filter {
@@ -81,35 +421,235 @@ func (e *extractor) extractAndSend(routerName string, metricID string, filter t1
 		}
 	}
 
-	points, err := e.client.GetMetric(routerName, &t128.AnalyticMetricRequest{
-		ID:        "/stats/" + metricID,
-		Transform: "sum",
-		Window:    window,
-		Filters:   routerlessFilter,
-	})
+	return &metricQuery{
+		metricJob: job,
+		duration:  duration,
+		request: &t128.AnalyticMetricRequest{
+			ID:        "/stats/" + metricID,
+			Transform: "sum",
+			Window:    window,
+			Filters:   routerlessFilter,
+		},
+	}
+}
+
+func (e *extractor) send(query *metricQuery, points []t128.AnalyticPoint, err error) {
+	paramStr := query.filter.ToString()
 
 	if err != nil {
-		logger.Log.Error("HTTP request for %v(%v) failed: %v\n", metricID, paramStr, err.Error())
+		logger.Log.Error("HTTP request for %v(%v) failed: %v\n", query.metricID, paramStr, err.Error())
 		return
 	}
 
-	if err = e.influxClient.Send(metricID, filter, points); err != nil {
-		logger.Log.Error("Influx write for %v(%v) failed: %v\n", metricID, paramStr, err.Error())
+	tags := make(map[string]string, len(query.filter)+len(query.metadata.tags)+1)
+	for k, v := range query.filter {
+		tags[k] = v
+	}
+	for k, v := range query.metadata.tags {
+		tags[k] = v
+	}
+
+	valueField := "value"
+	if query.units != "" {
+		switch e.config.Metrics.Units {
+		case config.UnitsTag:
+			tags["units"] = query.units
+		case config.UnitsField:
+			if suffix := unitsFieldSuffix(query.units); suffix != "" {
+				valueField = "value_" + suffix
+			}
+		}
+	}
+
+	if e.registry != nil {
+		e.exportLatest(query, tags, points)
+		return
+	}
+
+	// Prometheus and Graphite are sent the metric regardless of whether Influx accepts it
+	if e.prometheus != nil {
+		if err := e.prometheus.Send(query.metricID, e.tags.Apply(tags), points); err != nil {
+			logger.Log.Error("Prometheus write for %v(%v) failed: %v\n", query.metricID, paramStr, err.Error())
+		}
+	}
+
+	// Graphite paths are only built from the permutation so location metadata is left out
+	if e.graphite != nil {
+		if err := e.graphite.Send(query.metricID, e.tags.Apply(query.filter), points); err != nil {
+			logger.Log.Error("Graphite write for %v(%v) failed: %v\n", query.metricID, paramStr, err.Error())
+		}
+	}
+
+	if err = e.influxClient.Send(query.metricID, valueField, tags, query.metadata.fields, points); err != nil {
+		logger.Log.Error("Influx write for %v(%v) failed: %v\n", query.metricID, paramStr, err.Error())
 		return
 	}
 
-	logger.Log.Info("Exported last %v seconds of %v(%v).", endTime, metricID, paramStr)
+	logger.Log.Info("Exported last %v seconds of %v(%v).", query.duration, query.metricID, paramStr)
 }
 
-func (e *extractor) extract() error {
-	routers, err := e.client.GetRouters()
+// exportLatest records the most recent point of a metric permutation in the registry
+func (e *extractor) exportLatest(query *metricQuery, tags map[string]string, points []t128.AnalyticPoint) {
+	var latest *t128.AnalyticPoint
+	var latestTime time.Time
+	for i, point := range points {
+		t, err := time.Parse(time.RFC3339, point.Time)
+		if err != nil {
+			logger.Log.Error("Invalid time %v for %v(%v): %v\n", point.Time, query.metricID, query.filter.ToString(), err.Error())
+			return
+		}
+
+		if latest == nil || t.After(latestTime) {
+			latest = &points[i]
+			latestTime = t
+		}
+	}
+
+	if latest != nil {
+		e.registry.Set(query.metricID, e.tags.Apply(tags), latest.Value)
+	}
+}
+
+func (e *extractor) extractAndSend(routerName string, job metricJob) {
+	if e.circuitOpen(routerName) {
+		return
+	}
+
+	query := e.createMetricQuery(job)
+
+	var points []t128.AnalyticPoint
+	err := e.request(routerName, func() (err error) {
+		points, err = e.client.GetMetric(routerName, query.request)
+		return
+	})
+
+	if err == errCircuitOpen {
+		return
+	}
+
+	e.send(query, points, err)
+}
+
+// extractAndSendBatch retrieves many metric permutations for a router with a single request
+func (e *extractor) extractAndSendBatch(routerName string, batch []metricJob) {
+	if e.circuitOpen(routerName) {
+		return
+	}
+
+	queries := make([]*metricQuery, len(batch))
+	requests := make([]*t128.AnalyticMetricRequest, len(batch))
+	for i, job := range batch {
+		queries[i] = e.createMetricQuery(job)
+		requests[i] = queries[i].request
+	}
+
+	var results []t128.MetricResult
+	err := e.request(routerName, func() (err error) {
+		results, err = e.client.GetMetrics(routerName, requests)
+		return
+	})
+
+	if err == errCircuitOpen {
+		return
+	} else if err != nil {
+		// The whole query failing usually means the 128T can't serve metrics through
+		// GraphQL, so the permutations are requested individually instead
+		logger.Log.Warn("Batched metric request for router %v failed: %v. Falling back to individual metric requests.\n",
+			routerName, err.Error())
+		for _, job := range batch {
+			e.extractAndSend(routerName, job)
+		}
+		return
+	}
+
+	for i, query := range queries {
+		e.send(query, results[i].Points, results[i].Err)
+	}
+}
+
+// unitsFieldSuffix converts units into something suitable for a field name, e.g. "bits/s" becomes "bits_s"
+func unitsFieldSuffix(units string) string {
+	return strings.Trim(invalidUnitsChars.ReplaceAllString(strings.ToLower(units), "_"), "_")
+}
+
+// metricJob represents a metric permutation waiting to be extracted
+type metricJob struct {
+	metricID string
+	units    string
+	filter   t128.AnalyticMetricFilter
+	metadata routerMetadata
+}
+
+// routerMetadata represents information about a router that is added to each of its metric points
+type routerMetadata struct {
+	tags   map[string]string
+	fields map[string]interface{}
+}
+
+func (e *extractor) createRouterMetadata(router t128.Router) routerMetadata {
+	metadata := routerMetadata{
+		tags:   make(map[string]string),
+		fields: make(map[string]interface{}),
+	}
+
+	if router.Location == "" {
+		return metadata
+	}
+
+	lat, lon, err := router.Coordinates()
 	if err != nil {
-		return fmt.Errorf("unable to retrieve routers: %v", err.Error())
+		logger.Log.Warn(
+			"Failed translating router %v's location %v to coordinates: %v",
+			router.Name, router.Location, err.Error())
+		return metadata
+	}
+
+	if e.config.Location.GeohashPrecision > 0 {
+		metadata.tags["geohash"], _ = router.LocationGeohashWithPrecision(e.config.Location.GeohashPrecision)
+	}
+
+	if e.config.Location.Coordinates {
+		metadata.fields["latitude"] = lat
+		metadata.fields["longitude"] = lon
+	}
+
+	return metadata
+}
+
+// metricBatchSize determines how many metric permutations are requested at once.
+// Batching through GraphQL is experimental and opt-in as its query isn't part of a
+// documented 128T schema.
+func (e *extractor) metricBatchSize() int {
+	if e.config.Application.MetricBatchSize <= 1 {
+		return 1
+	}
+
+	return e.config.Application.MetricBatchSize
+}
+
+// discover retrieves the target's routers and metric metadata along with how many
+// metric permutations can be requested at once
+func (e *extractor) discover() ([]t128.Router, map[string]*t128.MetricDescriptor, int, error) {
+	// The version is checked first as a new version invalidates everything cached
+	if info, err := e.client.GetSystemInfo(); err == nil {
+		e.cache.SetVersion(info.Version)
+	}
+
+	allRouters, err := e.cache.Routers(e.client.GetRouters)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("unable to retrieve routers: %v", err.Error())
+	}
+
+	routers := make([]t128.Router, 0, len(allRouters))
+	for _, router := range allRouters {
+		if e.target.IncludesRouter(router.Name) {
+			routers = append(routers, router)
+		}
 	}
 
-	metricDescriptors, err := e.client.GetMetricMetadata()
+	metricDescriptors, err := e.cache.Metadata(e.client.GetMetricMetadata)
 	if err != nil {
-		return fmt.Errorf("unable to retrieve metric metadata: %v", err.Error())
+		return nil, nil, 0, fmt.Errorf("unable to retrieve metric metadata: %v", err.Error())
 	}
 
 	descriptorMap := make(map[string]*t128.MetricDescriptor)
@@ -117,6 +657,22 @@ func (e *extractor) extract() error {
 		descriptorMap[desc.ID] = desc
 	}
 
+	return routers, descriptorMap, e.metricBatchSize(), nil
+}
+
+func (e *extractor) extract() error {
+	routers, descriptorMap, batchSize, err := e.discover()
+	if err != nil {
+		return err
+	}
+
+	e.recordMetricMetadata(descriptorMap)
+
+	// Every request to the 128T runs through the pool so that a router with many
+	// permutations can't starve the others or flood the conductor. The router
+	// semaphore only bounds how many routers are being discovered at once.
+	jobs := pool.New(e.config.Application.MaxConcurrentRequests, e.config.Application.MaxConcurrentRouterRequests)
+
 	var wg sync.WaitGroup
 	sem := semaphore.New(e.config.Application.MaxConcurrentRouters)
 
@@ -128,53 +684,278 @@ func (e *extractor) extract() error {
 			defer sem.Release()
 			defer wg.Done()
 
-			for _, metricID := range e.config.Metrics.Metrics {
-				descriptor, ok := descriptorMap[metricID]
-				if !ok {
-					logger.Log.Warn("%v is not a valid metric within the system. Skipping...", metricID)
-					continue
-				}
+			reachable := e.probe(router.Name)
 
-				permutations, err := e.client.GetMetricPermutations(router.Name, *descriptor)
-				if err != nil {
-					logger.Log.Error("Error retriving permutations for %v on router %v: %v\n", metricID, router.Name, err)
-					continue
-				}
+			if e.config.Inventory.Enabled {
+				jobs.Do(router.Name, func() {
+					e.collectInventory(router, reachable)
+				})
+			}
 
-				for _, permutation := range permutations {
-					filter := make(t128.AnalyticMetricFilter)
-					filter["router"] = router.Name
+			if !reachable {
+				return
+			}
+
+			e.collectMetrics(router, descriptorMap, batchSize, jobs)
+
+			var err error
+			var active []t128.Alarm
+			snapshotTime := time.Now()
+			if e.config.ActiveAlarms.Enabled {
+				jobs.Do(router.Name, func() {
+					active, err = e.collectActiveAlarms(router)
+				})
+				if err != nil && err != errCircuitOpen {
+					logger.Log.Error("Failed retriving active alarms for %v: %v\n", router.Name, err.Error())
+				}
+			}
 
-					for key := range permutation.Parameters {
-						filter[key] = permutation.Parameters[key]
+			if e.config.Events.Enabled {
+				for _, eventType := range e.config.Events.Types {
+					jobs.Do(router.Name, func() {
+						err = e.collectEvents(router, eventType)
+					})
+					if err != nil && err != errCircuitOpen {
+						logger.Log.Error("Failed retriving %v event history for %v: %v\n", eventType.Type, router.Name, err.Error())
 					}
 
-					e.extractAndSend(router.Name, descriptor.ID, filter)
+					// Every clear event up to now has been seen so any alarm the snapshot no
+					// longer reports will never be paired
+					if eventType.Type == "ALARM" && err == nil && active != nil {
+						e.retainOpenAlarms(router, active, snapshotTime)
+					}
 				}
+			}
+		}(router)
+	}
+
+	wg.Wait()
+	jobs.Wait()
+
+	if err := e.cache.Save(); err != nil {
+		logger.Log.Error("Unable to save cache: %v\n", err.Error())
+	}
 
+	if e.alarms != nil {
+		if maxAge := e.config.Events.AlarmDurationMaxAge; maxAge > 0 {
+			if expired := e.alarms.Expire(time.Now().Add(-time.Duration(maxAge) * time.Second)); expired != 0 {
+				logger.Log.Warn("Forgot %v open alarms raised more than %v seconds ago without being cleared\n", expired, maxAge)
 			}
+		}
 
-			if e.config.AlarmHistory.Enabled {
-				if err := e.collectAlarmHistory(router); err != nil {
-					logger.Log.Error("Failed retriving alarm history for %v: %v\n", router.Name, err.Error())
-				}
+		if err := e.alarms.Save(); err != nil {
+			logger.Log.Error("Unable to save open alarms: %v\n", err.Error())
+		}
+	}
+
+	e.recordCircuitBreakers()
+
+	if e.graphite != nil {
+		if err := e.graphite.Close(); err != nil {
+			logger.Log.Error("Unable to send buffered datapoints to Graphite: %v\n", err.Error())
+		}
+	}
+
+	if err := e.influxClient.Close(); err != nil {
+		return fmt.Errorf("unable to write buffered points to Influx: %v", err)
+	}
+
+	return nil
+}
+
+// collectMetrics discovers the permutations of every enabled metric on a router and
+// submits jobs to extract them
+func (e *extractor) collectMetrics(router t128.Router, descriptorMap map[string]*t128.MetricDescriptor, batchSize int, jobs *pool.Pool) {
+	metadata := e.createRouterMetadata(router)
+
+	var err error
+	var batch []metricJob
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		pending := batch
+		batch = nil
+		jobs.Submit(router.Name, func() {
+			e.extractAndSendBatch(router.Name, pending)
+		})
+	}
+
+	for _, metricID := range e.config.Metrics.Metrics {
+		if e.circuitOpen(router.Name) {
+			logger.Log.Warn("Circuit breaker for router %v is open. Skipping its remaining metrics.\n", router.Name)
+			break
+		}
+
+		descriptor, ok := descriptorMap[metricID]
+		if !ok {
+			logger.Log.Warn("%v is not a valid metric within the system. Skipping...", metricID)
+			continue
+		}
+
+		var permutations []*t128.MetricPermutation
+		jobs.Do(router.Name, func() {
+			permutations, err = e.cache.Permutations(router.Name, metricID, func() (permutations []*t128.MetricPermutation, err error) {
+				err = e.request(router.Name, func() (err error) {
+					permutations, err = e.client.GetMetricPermutations(router.Name, *descriptor)
+					return
+				})
+				return
+			})
+		})
+		if err == errCircuitOpen {
+			continue
+		} else if err != nil {
+			logger.Log.Error("Error retriving permutations for %v on router %v: %v\n", metricID, router.Name, err)
+			continue
+		}
+
+		for _, permutation := range permutations {
+			filter := make(t128.AnalyticMetricFilter)
+			filter["router"] = router.Name
+
+			for key := range permutation.Parameters {
+				filter[key] = permutation.Parameters[key]
+			}
+
+			job := metricJob{metricID: descriptor.ID, units: descriptor.Units, filter: filter, metadata: metadata}
+			if batchSize <= 1 {
+				jobs.Submit(router.Name, func() {
+					e.extractAndSend(router.Name, job)
+				})
+				continue
+			}
+
+			batch = append(batch, job)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		}
+	}
+
+	flush()
+}
+
+// export fetches the enabled metrics of every router and records their latest values
+// in the registry
+func (e *extractor) export() error {
+	routers, descriptorMap, batchSize, err := e.discover()
+	if err != nil {
+		return err
+	}
+
+	for _, metricID := range e.config.Metrics.Metrics {
+		if descriptor, ok := descriptorMap[metricID]; ok {
+			e.registry.Describe(descriptor)
+		}
+	}
+
+	if e.breaker != nil {
+		e.breaker.NextCycle()
+	}
+
+	jobs := pool.New(e.config.Application.MaxConcurrentRequests, e.config.Application.MaxConcurrentRouterRequests)
+
+	var wg sync.WaitGroup
+	sem := semaphore.New(e.config.Application.MaxConcurrentRouters)
+
+	for _, router := range routers {
+		wg.Add(1)
+
+		go func(router t128.Router) {
+			sem.Acquire()
+			defer sem.Release()
+			defer wg.Done()
+
+			if e.probe(router.Name) {
+				e.collectMetrics(router, descriptorMap, batchSize, jobs)
 			}
 		}(router)
 	}
 
 	wg.Wait()
+	jobs.Wait()
+
+	if err := e.cache.Save(); err != nil {
+		logger.Log.Error("Unable to save cache: %v\n", err.Error())
+	}
+
+	e.recordCircuitBreakers()
 	return nil
 }
 
-func (e *extractor) collectAlarmHistory(router t128.Router) error {
-	maxStartTime := time.Now().Add(-time.Duration(e.config.AlarmHistory.QueryTime) * time.Second)
+// runExporter serves the latest metric values on /metrics, refreshing them every interval
+func runExporter() error {
+	cfg, err := config.Load(*exporterConfigFile)
+	if err != nil {
+		return err
+	}
+
+	registry := prometheus.NewRegistry(cfg.Prometheus.MetricPrefix)
+	extractors, err := createExtractors(cfg, registry)
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(cfg.Exporter.Interval) * time.Second
+	go func() {
+		for {
+			start := time.Now()
+
+			var wg sync.WaitGroup
+			for _, ext := range extractors {
+				wg.Add(1)
+				go func(ext *extractor) {
+					defer wg.Done()
+					if err := ext.export(); err != nil {
+						if ext.target.Name != "" {
+							logger.Log.Error("Export of target %v failed: %v\n", ext.target.Name, err.Error())
+						} else {
+							logger.Log.Error("Export failed: %v\n", err.Error())
+						}
+					}
+				}(ext)
+			}
+			wg.Wait()
+
+			// Series that haven't been seen for a few cycles belong to routers or
+			// permutations that no longer exist
+			registry.Expire(start.Add(-2 * interval))
+
+			if elapsed := time.Since(start); elapsed < interval {
+				time.Sleep(interval - elapsed)
+			} else {
+				logger.Log.Warn("Exporting took %v which is longer than the %v interval\n", elapsed, interval)
+			}
+		}
+	}()
+
+	http.Handle("/metrics", registry)
+	logger.Log.Info("Serving metrics on %v/metrics\n", cfg.Exporter.Listen)
+	return http.ListenAndServe(cfg.Exporter.Listen, nil)
+}
+
+// eventTimestamp determines the time an event is written at. IMPORTANT: records that are
+// time & tag matches will end up replacing previous items within the influx database, so
+// events sharing a timestamp need to be differentiated. A tag would significantly increase
+// the cardinality of the index which influx doesn't like, and encoding the event's position
+// within a response changes between runs. Instead, the event's hash is used to offset it by
+// a stable amount below the millisecond precision of the 128T, so retrieving the same event
+// again overwrites it rather than duplicating it.
+func eventTimestamp(evt t128.AuditEvent) time.Time {
+	return evt.Timestamp.Add(time.Duration(evt.Hash() % uint64(time.Millisecond)))
+}
+
+func (e *extractor) collectEvents(router t128.Router, eventType config.EventTypeConfig) error {
+	maxStartTime := time.Now().Add(-time.Duration(e.config.Events.QueryTime) * time.Second)
 
-	lastRecordedTime, err := e.influxClient.LastRecordedTime(alarmHistorySeriesName, map[string]string{
+	lastRecordedTime, err := e.influxClient.LastInsertedTime(eventType.Measurement, map[string]string{
 		"router": router.Name,
 	})
 	if err != nil {
-		logger.Log.Warn("Unable to retrieve last recorded time for alarm-history: %v. Starting from %v\n",
-			err.Error(), maxStartTime.Format(time.RFC3339))
+		logger.Log.Warn("Unable to retrieve last recorded time for %v: %v. Starting from %v\n",
+			eventType.Measurement, err.Error(), maxStartTime.Format(time.RFC3339))
 		lastRecordedTime = &maxStartTime
 	} else {
 		if lastRecordedTime.Unix() < maxStartTime.Unix() {
@@ -182,14 +963,322 @@ func (e *extractor) collectAlarmHistory(router t128.Router) error {
 		}
 	}
 
-	// We have to adjust the last recorded time by the smallest amount possible so that
-	// we don't keep picking up the same event at that time over and over again
-	startTime := lastRecordedTime.Add(1 * time.Second)
-	timeDelta := time.Now().Sub(startTime).Seconds()
+	// Events are written at deterministic times so the window can safely start at the
+	// last recorded event. Any events that are retrieved again overwrite themselves.
+	watermark := *lastRecordedTime
+	annotated := make(map[int64]bool)
+	startTime := lastRecordedTime.Truncate(time.Second)
+	endTime := time.Now()
+	chunkSize := time.Duration(e.config.Events.ChunkSize) * time.Second
+	pageSize := e.config.Events.PageSize
 
-	events, err := e.client.GetAuditEvents(router.Name, []string{"ALARM"}, startTime, time.Now())
+	geohash, err := router.LocationGeohash()
 	if err != nil {
-		return err
+		logger.Log.Warn(
+			"Failed translating router %v's location %v to geo hash: %v",
+			router.Name, router.Location, err.Error())
+	}
+
+	// Each chunk is written, flushing any buffered points, before the next is requested so
+	// that the last recorded time, and with it where the next run starts, advances as each
+	// chunk completes.
+	for chunkStart := startTime; chunkStart.Before(endTime); {
+		chunkEnd := chunkStart.Add(chunkSize)
+		if chunkEnd.After(endTime) {
+			chunkEnd = endTime
+		}
+
+		var recordCount, rejections int
+		page := func(start time.Time, end time.Time, limit int) ([]t128.AuditEvent, error) {
+			var events []t128.AuditEvent
+			err := e.request(router.Name, func() (err error) {
+				events, err = e.client.GetAuditEvents(router.Name, []string{eventType.Type}, start, end, limit)
+				return
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			pageRejections, err := e.writeEvents(router, eventType, geohash, watermark, annotated, events)
+			if err != nil {
+				return nil, err
+			}
+
+			recordCount += len(events)
+			rejections += pageRejections
+			return events, nil
+		}
+
+		for pageStart := chunkStart; pageStart.Before(chunkEnd); {
+			events, err := page(pageStart, chunkEnd, pageSize)
+			if err != nil {
+				return err
+			}
+
+			// A page with more events than the limit means the 128T ignored the limit and
+			// returned the whole chunk
+			if pageSize == 0 || len(events) != pageSize {
+				break
+			}
+
+			// The next page starts at the last event returned. If an entire page shares the same
+			// second there's no way to page past it, so that second is fetched without a limit.
+			nextStart := events[len(events)-1].Timestamp.Truncate(time.Second)
+			if !nextStart.After(pageStart) {
+				nextStart = pageStart.Add(time.Second)
+				if nextStart.After(chunkEnd) {
+					nextStart = chunkEnd
+				}
+
+				if _, err := page(pageStart, nextStart, 0); err != nil {
+					return err
+				}
+			}
+			pageStart = nextStart
+		}
+
+		if err := e.influxClient.Flush(); err != nil {
+			return fmt.Errorf("unable to write buffered points to Influx: %v", err)
+		}
+
+		logger.Log.Info("Exported %v seconds (%v items, %v rejected values) of %v history from %v\n",
+			int(chunkEnd.Sub(chunkStart).Seconds()), recordCount, rejections, eventType.Type, router.Name)
+
+		chunkStart = chunkEnd
+	}
+
+	return nil
+}
+
+// writeEvents writes a page of events to Influx and returns the number of values rejected.
+// Events at or before the watermark have been written by a previous run and the times of
+// those annotated by this run are held in annotated.
+func (e *extractor) writeEvents(router t128.Router, eventType config.EventTypeConfig, geohash string, watermark time.Time, annotated map[int64]bool, events []t128.AuditEvent) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+
+	tagKeys := make(map[string]bool)
+	for _, k := range eventType.Tags {
+		tagKeys[k] = true
+	}
+
+	fieldKeys := make(map[string]bool)
+	for _, k := range eventType.Fields {
+		fieldKeys[k] = true
+	}
+
+	var rejections int
+	records := make([]influx.Record, len(events))
+	for i, evt := range events {
+		timestamp := eventTimestamp(evt)
+
+		record := influx.Record{
+			Time: timestamp,
+			Tags: map[string]string{
+				"router":  evt.Router,
+				"node":    evt.Node,
+				"geohash": geohash,
+			},
+		}
+
+		tags, fields, eventRejections := event.Fields(event.Flatten(evt.Data), tagKeys, fieldKeys, eventType.FieldTypes)
+		for k, v := range tags {
+			record.Tags[k] = v
+		}
+		record.Fields = fields
+		rejections += len(eventRejections)
+
+		for _, rejection := range eventRejections {
+			logger.Log.Warn("Rejected %v value %v=%v from router %v: %v\n",
+				eventType.Type, rejection.Key, rejection.Value, router.Name, rejection.Reason.Error())
+		}
+
+		// Influx refuses points without fields which the allowlist may have caused
+		if len(record.Fields) == 0 {
+			record.Fields["type"] = evt.Type
+		}
+
+		records[i] = record
+	}
+
+	if eventType.Type != "ALARM" {
+		return rejections, e.influxClient.Insert(eventType.Measurement, records)
+	}
+
+	// Durations are written before the events so that if either write fails the next run
+	// retrieves the clear events again while their alarms are still open
+	cleared, err := e.trackAlarmDurations(router, events, records)
+	if err != nil {
+		return rejections, err
+	}
+
+	if err := e.influxClient.Insert(eventType.Measurement, records); err != nil {
+		return rejections, err
+	}
+
+	for _, c := range cleared {
+		e.alarms.Forget(router.Name, c.id, c.start)
+	}
+
+	e.annotateAlarms(events, records, watermark, annotated)
+	return rejections, nil
+}
+
+// annotateAlarms posts each newly raised alarm to Grafana as an annotation, adding the
+// times of those posted to annotated
+func (e *extractor) annotateAlarms(events []t128.AuditEvent, records []influx.Record, watermark time.Time, annotated map[int64]bool) {
+	if e.grafana == nil {
+		return
+	}
+
+	for i, evt := range events {
+		// Alarms retrieved again because they share the watermark's second were posted by a
+		// previous run, and those on overlapping pages were posted by this one
+		if evt.SubType != t128.AlarmRaised || !records[i].Time.After(watermark) || annotated[records[i].Time.UnixNano()] {
+			continue
+		}
+		annotated[records[i].Time.UnixNano()] = true
+
+		tags := append([]string{
+			"router:" + evt.Router,
+			"node:" + evt.Node,
+		}, e.config.Grafana.Tags...)
+
+		text := fmt.Sprint(evt.Data["message"])
+		if severity, ok := evt.Data["severity"]; ok {
+			text = fmt.Sprintf("[%v] %v", severity, text)
+		}
+
+		if err := e.grafana.Annotate(grafana.NewAnnotation(records[i].Time, text, tags)); err != nil {
+			logger.Log.Error("Failed posting alarm annotation for %v to Grafana: %v\n", evt.Router, err.Error())
+		}
+	}
+}
+
+// retainOpenAlarms forgets the open alarms of a router that were cleared before the
+// active alarm snapshot was taken without their clear event being seen
+func (e *extractor) retainOpenAlarms(router t128.Router, active []t128.Alarm, snapshotTime time.Time) {
+	if e.alarms == nil {
+		return
+	}
+
+	ids := make([]string, 0, len(active))
+	for _, a := range active {
+		ids = append(ids, a.Get("id"))
+	}
+
+	if forgotten := e.alarms.Retain(router.Name, ids, snapshotTime); forgotten != 0 {
+		logger.Log.Warn("Forgot %v open alarms of router %v that are no longer active\n", forgotten, router.Name)
+	}
+}
+
+// clearedAlarm identifies an alarm whose duration has been recorded
+type clearedAlarm struct {
+	id    string
+	start time.Time
+}
+
+// trackAlarmDurations pairs the raise and clear events of alarms and records how long
+// each cleared alarm was active. The cleared alarms are returned so that they can be
+// forgotten once their events have been written.
+func (e *extractor) trackAlarmDurations(router t128.Router, events []t128.AuditEvent, records []influx.Record) ([]clearedAlarm, error) {
+	if e.alarms == nil {
+		return nil, nil
+	}
+
+	var durations []influx.Record
+	var cleared []clearedAlarm
+	for i, evt := range events {
+		id := evt.AlarmID()
+		if id == "" {
+			continue
+		}
+
+		switch evt.SubType {
+		case t128.AlarmRaised:
+			e.alarms.Raise(router.Name, id, evt.Timestamp, records[i].Tags)
+		case t128.AlarmCleared:
+			duration, ok := e.alarms.Clear(router.Name, id, evt.Timestamp)
+			if !ok {
+				continue
+			}
+
+			cleared = append(cleared, clearedAlarm{id: id, start: duration.Start})
+			durations = append(durations, influx.Record{
+				Time: records[i].Time,
+				Tags: duration.Tags,
+				Fields: map[string]interface{}{
+					"start":    duration.Start.Format(time.RFC3339Nano),
+					"end":      duration.End.Format(time.RFC3339Nano),
+					"duration": duration.End.Sub(duration.Start).Seconds(),
+				},
+			})
+		}
+	}
+
+	if len(durations) == 0 {
+		return nil, nil
+	}
+
+	if err := e.influxClient.Insert(alarmDurationSeriesName, durations); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("Exported %v alarm durations from %v\n", len(durations), router.Name)
+	return cleared, nil
+}
+
+// recordMetricMetadata writes the description and units of each enabled metric so that
+// dashboards can label their axes
+func (e *extractor) recordMetricMetadata(descriptors map[string]*t128.MetricDescriptor) {
+	now := time.Now()
+	records := make([]influx.Record, 0, len(e.config.Metrics.Metrics))
+	for _, metricID := range e.config.Metrics.Metrics {
+		descriptor, ok := descriptors[metricID]
+		if !ok {
+			continue
+		}
+
+		records = append(records, influx.Record{
+			Time: now,
+			Tags: map[string]string{"metric": descriptor.ID},
+			Fields: map[string]interface{}{
+				"description": descriptor.Description,
+				"units":       descriptor.Units,
+			},
+		})
+	}
+
+	if len(records) == 0 {
+		return
+	}
+
+	if err := e.influxClient.Insert(metadataSeriesName, records); err != nil {
+		logger.Log.Error("Influx write for %v failed: %v\n", metadataSeriesName, err.Error())
+	}
+}
+
+// collectInventory records a snapshot of the router's nodes. Routers that can't be
+// reached are still recorded so that they show up as unreachable.
+func (e *extractor) collectInventory(router t128.Router, reachable bool) {
+	var nodes []t128.Node
+	if reachable {
+		err := e.request(router.Name, func() (err error) {
+			nodes, err = e.client.GetNodes(router.Name)
+			return
+		})
+		if err != nil {
+			logger.Log.Warn("Unable to retrieve the nodes of router %v: %v\n", router.Name, err.Error())
+			reachable = false
+		}
+	}
+
+	// Without nodes the router itself is recorded
+	if len(nodes) == 0 {
+		nodes = []t128.Node{{}}
 	}
 
 	geohash, err := router.LocationGeohash()
@@ -199,42 +1288,117 @@ func (e *extractor) collectAlarmHistory(router t128.Router) error {
 			router.Name, router.Location, err.Error())
 	}
 
-	records := make([]influx.Record, len(events))
-	for i, event := range events {
-		// IMPORTANT: records that are time & tag matches will end up replacing previous items
-		// within the influx database. This means that we need to differentiate items. To do
-		// this we could add a tag but that would most likely prove hazardous as it would significatly
-		// increase the cardinality of the index which influx doesn't like. So, we'll use the nanosecond
-		// resolution of the time to encode the index of the event.
-		timestamp := event.Timestamp.Add(time.Duration(i) * time.Nanosecond)
+	now := time.Now()
+	records := make([]influx.Record, len(nodes))
+	for i, node := range nodes {
+		role := "router"
+		if strings.EqualFold(node.Role, "conductor") {
+			role = "conductor"
+		}
 
-		record := influx.Record{
-			Time:   timestamp,
-			Fields: map[string]interface{}{},
+		records[i] = influx.Record{
+			Time: now,
 			Tags: map[string]string{
-				"router":  event.Router,
-				"node":    event.Node,
+				"router":  router.Name,
+				"node":    node.Name,
+				"role":    role,
 				"geohash": geohash,
 			},
+			Fields: map[string]interface{}{
+				"version":   node.SoftwareVersion,
+				"location":  router.Location,
+				"reachable": reachable,
+			},
+		}
+	}
+
+	if err := e.influxClient.Insert(inventorySeriesName, records); err != nil {
+		logger.Log.Error("Influx write for %v of router %v failed: %v\n", inventorySeriesName, router.Name, err.Error())
+		return
+	}
+
+	logger.Log.Info("Exported inventory of %v nodes from %v\n", len(records), router.Name)
+}
+
+// collectActiveAlarms records a snapshot of a router's active alarms. The alarms are
+// returned whenever they could be retrieved, even if recording them fails.
+func (e *extractor) collectActiveAlarms(router t128.Router) ([]t128.Alarm, error) {
+	var alarms []t128.Alarm
+	err := e.request(router.Name, func() (err error) {
+		alarms, err = e.client.GetAlarms(router.Name)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty snapshot is still a snapshot
+	if alarms == nil {
+		alarms = []t128.Alarm{}
+	}
+
+	now := time.Now().Truncate(time.Millisecond)
+	counts := make(map[string]int)
+	for _, severity := range t128.AlarmSeverities {
+		counts[severity] = 0
+	}
+
+	records := make([]influx.Record, 0, len(alarms))
+	for _, active := range alarms {
+		// Alarms sharing every tag, such as two interface-down alarms on one node, would
+		// overwrite each other so each is offset below the millisecond like events are
+		record := influx.Record{
+			Time:   now.Add(time.Duration(active.Hash() % uint64(time.Millisecond))),
+			Fields: map[string]interface{}{},
+			Tags:   map[string]string{"router": router.Name},
+		}
+
+		for _, tag := range activeAlarmTags {
+			record.Tags[tag] = active.Get(tag)
 		}
 
-		for k, v := range event.Data {
-			if v != nil {
+		for k, v := range active {
+			if _, isTag := record.Tags[k]; isTag {
+				continue
+			}
+
+			switch v.(type) {
+			case string, float64, bool:
 				record.Fields[k] = v
 			}
 		}
 
-		records[i] = record
+		// Influx refuses points without fields so make sure there's always one
+		record.Fields["active"] = true
+
+		counts[record.Tags["severity"]]++
+		records = append(records, record)
 	}
 
-	recordCount := len(records)
-	logger.Log.Info("Exported last %v seconds (%v items) of alarm history from %v\n",
-		int(timeDelta), recordCount, router.Name)
-	if recordCount != 0 {
-		return e.influxClient.Insert(alarmHistorySeriesName, records)
+	countRecords := make([]influx.Record, 0, len(counts))
+	for severity, count := range counts {
+		countRecords = append(countRecords, influx.Record{
+			Time:   now,
+			Fields: map[string]interface{}{"count": count},
+			Tags: map[string]string{
+				"router":   router.Name,
+				"severity": severity,
+			},
+		})
 	}
 
-	return nil
+	if len(records) != 0 {
+		if err := e.influxClient.Insert(activeAlarmsSeriesName, records); err != nil {
+			return alarms, err
+		}
+	}
+
+	if err := e.influxClient.Insert(activeAlarmCountSeriesName, countRecords); err != nil {
+		return alarms, err
+	}
+
+	logger.Log.Info("Exported %v active alarms from %v\n", len(records), router.Name)
+	return alarms, nil
 }
 
 func initConfig() error {
@@ -296,6 +1460,51 @@ func initConfig() error {
 	return nil
 }
 
+// setupInflux creates everything the configuration writes to for every target
+func setupInflux() error {
+	cfg, err := config.Load(*setupConfigFile)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Influx.Protocol == config.ProtocolUDP {
+		return fmt.Errorf("Influx can't be set up over udp. Create the database used by the UDP listener by hand")
+	}
+
+	policies := make([]influx.RetentionPolicy, len(cfg.Policies))
+	for i, policy := range cfg.Policies {
+		policies[i] = influx.RetentionPolicy{
+			Name:          policy.Name,
+			Duration:      policy.Duration,
+			ShardDuration: policy.ShardDuration,
+			Replication:   policy.Replication,
+			Default:       policy.Default,
+		}
+	}
+
+	var rollups []influx.Rollup
+	if cfg.Rollups.Enabled {
+		rollups = []influx.Rollup{
+			{Interval: "1h", Suffix: "_1h", Function: cfg.Rollups.Function, RetentionPolicy: cfg.Rollups.HourlyRetentionPolicy},
+			{Interval: "1d", Suffix: "_1d", Function: cfg.Rollups.Function, RetentionPolicy: cfg.Rollups.DailyRetentionPolicy},
+		}
+	}
+
+	for _, target := range cfg.Targets {
+		influxClient, err := createInfluxClient(cfg, target)
+		if err != nil {
+			return err
+		}
+
+		if err := influxClient.Setup(policies, rollups, cfg.Metrics.Metrics); err != nil {
+			return err
+		}
+	}
+
+	logger.Log.Info("Influx setup complete\n")
+	return nil
+}
+
 func main() {
 	app.Version(build)
 
@@ -304,14 +1513,52 @@ func main() {
 		if err := initConfig(); err != nil {
 			panic(err)
 		}
+	case setupCommand.FullCommand():
+		if err := setupInflux(); err != nil {
+			panic(err)
+		}
+	case exporterCommand.FullCommand():
+		if err := runExporter(); err != nil {
+			panic(err)
+		}
 	case extractCommand.FullCommand():
-		ext, err := createExtractor()
+		cfg, err := config.Load(*configFile)
 		if err != nil {
 			panic(err)
 		}
 
-		if err := ext.extract(); err != nil {
+		extractors, err := createExtractors(cfg, nil)
+		if err != nil {
 			panic(err)
 		}
+
+		// Each target is extracted independently so that one failing doesn't hold up the others
+		var wg sync.WaitGroup
+		errs := make([]error, len(extractors))
+		for i, ext := range extractors {
+			wg.Add(1)
+			go func(i int, ext *extractor) {
+				defer wg.Done()
+				errs[i] = ext.extract()
+			}(i, ext)
+		}
+		wg.Wait()
+
+		var failed error
+		for i, err := range errs {
+			if err == nil {
+				continue
+			}
+
+			if name := extractors[i].target.Name; name != "" {
+				err = fmt.Errorf("target %v: %v", name, err)
+				logger.Log.Error("%v\n", err.Error())
+			}
+			failed = err
+		}
+
+		if failed != nil {
+			panic(failed)
+		}
 	}
 }
@@ -3,50 +3,263 @@ package config
 import (
 	"fmt"
 	"io"
+	"path"
+	"regexp"
+	"strings"
 
 	"github.com/go-ini/ini"
 
 	"github.com/128technology/influx-importer/client"
+	"github.com/128technology/influx-importer/event"
 )
 
 // InfluxConfig represents the influx porition of the config
 type InfluxConfig struct {
-	Address  string `ini:"address"`
-	Username string `ini:"username"`
-	Password string `ini:"password"`
-	Database string `ini:"database"`
+	Address             string `ini:"address"`
+	Username            string `ini:"username"`
+	Password            string `ini:"password"`
+	Database            string `ini:"database"`
+	MeasurementPrefix   string `ini:"measurement-prefix"`
+	MeasurementTemplate string `ini:"measurement-template"`
+	WideMeasurement     string `ini:"wide-measurement"`
+	BatchSize           int    `ini:"batch-size"`
+	FlushInterval       int    `ini:"flush-interval"`
+	BufferSize          int    `ini:"buffer-size"`
+	MetricPrecision     string `ini:"metric-precision"`
+	SeriesPrecision     string `ini:"series-precision"`
+	Gzip                bool   `ini:"gzip"`
+	WriteConsistency    string `ini:"write-consistency"`
+	WriteTimeout        int    `ini:"write-timeout"`
+	Protocol            string `ini:"protocol"`
+	UDPPayloadSize      int    `ini:"udp-payload-size"`
+	WatermarkFile       string `ini:"watermark-file"`
+}
+
+// The protocols points can be written to Influx with
+const (
+	ProtocolHTTP = "http"
+	ProtocolUDP  = "udp"
+)
+
+// The protocols datapoints can be sent to Graphite with
+const (
+	GraphiteProtocolPlaintext = "plaintext"
+	GraphiteProtocolPickle    = "pickle"
+)
+
+// influxPrecisions are the precisions Influx accepts points in
+var influxPrecisions = []string{"ns", "u", "ms", "s", "m", "h"}
+
+// influxConsistencies are the write consistencies of clustered Influx instances
+var influxConsistencies = []string{"", "any", "one", "quorum", "all"}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
 }
 
 // ApplicationConfig represents the application porition of the config
 type ApplicationConfig struct {
-	MaxConcurrentRouters int `ini:"max-concurrent-routers"`
+	MaxConcurrentRouters        int `ini:"max-concurrent-routers"`
+	MaxConcurrentRequests       int `ini:"max-concurrent-requests"`
+	MaxConcurrentRouterRequests int `ini:"max-concurrent-requests-per-router"`
+	MetricBatchSize             int `ini:"metric-batch-size"`
 }
 
 // TargetConfig represents the target porition of the config
 type TargetConfig struct {
-	URL   string `ini:"url"`
-	Token string `ini:"token"`
+	Name                    string   `ini:"-"`
+	URL                     string   `ini:"url"`
+	Token                   string   `ini:"token"`
+	RequestsPerSecond       float64  `ini:"requests-per-second"`
+	RequestBurst            int      `ini:"request-burst"`
+	RouterRequestsPerSecond float64  `ini:"router-requests-per-second"`
+	RouterRequestBurst      int      `ini:"router-request-burst"`
+	Routers                 []string `ini:"routers"`
+	ExcludeRouters          []string `ini:"exclude-routers"`
+	Database                string   `ini:"database"`
+}
+
+// EventTypeConfig represents the configuration of a single audit event type
+type EventTypeConfig struct {
+	Type          string            `ini:"-"`
+	Measurement   string            `ini:"measurement"`
+	Tags          []string          `ini:"tags"`
+	Fields        []string          `ini:"fields"`
+	FieldTypeList []string          `ini:"field-types"`
+	FieldTypes    map[string]string `ini:"-"`
+}
+
+// EventsConfig represents the events portion of the config
+type EventsConfig struct {
+	Enabled                bool              `ini:"enabled"`
+	QueryTime              int               `ini:"max-query-time"`
+	TypeNames              []string          `ini:"types"`
+	ChunkSize              int               `ini:"chunk-size"`
+	PageSize               int               `ini:"page-size"`
+	AlarmDurationStateFile string            `ini:"alarm-duration-state-file"`
+	AlarmDurationMaxAge    int               `ini:"alarm-duration-max-age"`
+	Types                  []EventTypeConfig `ini:"-"`
+}
+
+// GrafanaConfig represents the grafana portion of the config
+type GrafanaConfig struct {
+	Enabled bool     `ini:"enabled"`
+	URL     string   `ini:"url"`
+	APIKey  string   `ini:"api-key"`
+	Tags    []string `ini:"tags"`
+}
+
+// PrometheusConfig represents the prometheus portion of the config
+type PrometheusConfig struct {
+	Enabled      bool   `ini:"enabled"`
+	URL          string `ini:"url"`
+	Username     string `ini:"username"`
+	Password     string `ini:"password"`
+	BearerToken  string `ini:"bearer-token"`
+	Tenant       string `ini:"tenant"`
+	MetricPrefix string `ini:"metric-prefix"`
+	Retries      int    `ini:"retries"`
+	Timeout      int    `ini:"timeout"`
+}
+
+// GraphiteConfig represents the graphite portion of the config
+type GraphiteConfig struct {
+	Enabled    bool   `ini:"enabled"`
+	Address    string `ini:"address"`
+	Protocol   string `ini:"protocol"`
+	Template   string `ini:"template"`
+	BatchSize  int    `ini:"batch-size"`
+	BufferSize int    `ini:"buffer-size"`
+	Timeout    int    `ini:"timeout"`
+}
+
+// ExporterConfig represents the exporter portion of the config
+type ExporterConfig struct {
+	Listen   string `ini:"listen"`
+	Interval int    `ini:"interval"`
+	Lookback int    `ini:"lookback"`
 }
 
-// AlarmHistoryConfig represents the alarms portion of the config
-type AlarmHistoryConfig struct {
-	Enabled   bool `ini:"enabled"`
-	QueryTime int  `ini:"max-query-time"`
+// LocationConfig represents the location portion of the config
+type LocationConfig struct {
+	GeohashPrecision uint `ini:"geohash-precision"`
+	Coordinates      bool `ini:"coordinates"`
+}
+
+// InventoryConfig represents the inventory portion of the config
+type InventoryConfig struct {
+	Enabled bool `ini:"enabled"`
+}
+
+// TagRewriteConfig represents a regular expression rewrite of a tag's value
+type TagRewriteConfig struct {
+	Key         string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// TagsConfig represents the tags portion of the config
+type TagsConfig struct {
+	Drop     []string `ini:"drop"`
+	Rename   map[string]string
+	Static   map[string]string
+	Rewrites []TagRewriteConfig
+}
+
+// RouteConfig represents a [route.NAME] portion of the config
+type RouteConfig struct {
+	Name            string   `ini:"-"`
+	Metrics         []string `ini:"metrics"`
+	Series          []string `ini:"series"`
+	Events          []string `ini:"events"`
+	Routers         []string `ini:"routers"`
+	Database        string   `ini:"database"`
+	RetentionPolicy string   `ini:"retention-policy"`
+}
+
+// RetentionPolicyConfig represents a [retention-policy.NAME] portion of the config
+type RetentionPolicyConfig struct {
+	Name          string `ini:"-"`
+	Duration      string `ini:"duration"`
+	ShardDuration string `ini:"shard-duration"`
+	Replication   int    `ini:"replication"`
+	Default       bool   `ini:"default"`
+}
+
+// RollupsConfig represents the rollups portion of the config
+type RollupsConfig struct {
+	Enabled               bool   `ini:"enabled"`
+	Function              string `ini:"function"`
+	HourlyRetentionPolicy string `ini:"hourly-retention-policy"`
+	DailyRetentionPolicy  string `ini:"daily-retention-policy"`
+}
+
+// influxDuration matches Influx's duration literals, e.g. "52w" or "1d12h"
+var influxDuration = regexp.MustCompile(`^(INF|([0-9]+(ns|u|µ|ms|s|m|h|d|w))+)$`)
+
+// rollupFunction matches the name of an Influx aggregate function, e.g. "mean"
+var rollupFunction = regexp.MustCompile(`^[a-z_]+$`)
+
+// ActiveAlarmsConfig represents the active-alarms portion of the config
+type ActiveAlarmsConfig struct {
+	Enabled bool `ini:"enabled"`
+}
+
+// CircuitBreakerConfig represents the circuit-breaker portion of the config
+type CircuitBreakerConfig struct {
+	Enabled          bool   `ini:"enabled"`
+	FailureThreshold int    `ini:"failure-threshold"`
+	StateFile        string `ini:"state-file"`
+}
+
+// CacheConfig represents the cache portion of the config
+type CacheConfig struct {
+	Enabled        bool   `ini:"enabled"`
+	File           string `ini:"file"`
+	MetadataTTL    int    `ini:"metadata-ttl"`
+	PermutationTTL int    `ini:"permutations-ttl"`
 }
 
 // MetricsConfig represents the metric portion of the config
 type MetricsConfig struct {
-	QueryTime int `ini:"max-query-time"`
+	QueryTime int    `ini:"max-query-time"`
+	Units     string `ini:"units"`
 	Metrics   []string
 }
 
+// The ways a metric's units can be written
+const (
+	UnitsNone  = "none"
+	UnitsTag   = "tag"
+	UnitsField = "field"
+)
+
 // Config represents the application's configuration
 type Config struct {
-	Target       TargetConfig
-	Application  ApplicationConfig
-	Influx       InfluxConfig
-	AlarmHistory AlarmHistoryConfig
-	Metrics      MetricsConfig
+	Targets        []TargetConfig
+	Application    ApplicationConfig
+	Influx         InfluxConfig
+	Events         EventsConfig
+	ActiveAlarms   ActiveAlarmsConfig
+	Inventory      InventoryConfig
+	Grafana        GrafanaConfig
+	Prometheus     PrometheusConfig
+	Exporter       ExporterConfig
+	Graphite       GraphiteConfig
+	CircuitBreaker CircuitBreakerConfig
+	Cache          CacheConfig
+	Location       LocationConfig
+	Tags           TagsConfig
+	Routes         []RouteConfig
+	Policies       []RetentionPolicyConfig
+	Rollups        RollupsConfig
+	Metrics        MetricsConfig
 }
 
 // Load loads a configuration file and returns a configuration object
@@ -63,7 +276,57 @@ func Load(filename string) (*Config, error) {
 		return nil, err
 	}
 
-	alarmHistory, err := getAlarmHistoryConfig(ini)
+	events, err := getEventsConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAlarms, err := getActiveAlarmsConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory, err := getInventoryConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	grafana, err := getGrafanaConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	circuitBreaker, err := getCircuitBreakerConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := getCacheConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := getLocationConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := getTagsConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := getRouteConfigs(ini, events)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := getRetentionPolicyConfigs(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	rollups, err := getRollupsConfig(ini)
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +336,22 @@ func Load(filename string) (*Config, error) {
 		return nil, err
 	}
 
-	target, err := getTargetConfig(ini)
+	targets, err := getTargetConfigs(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	prometheus, err := getPrometheusConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := getExporterConfig(ini)
+	if err != nil {
+		return nil, err
+	}
+
+	graphite, err := getGraphiteConfig(ini)
 	if err != nil {
 		return nil, err
 	}
@@ -83,17 +361,54 @@ func Load(filename string) (*Config, error) {
 		return nil, err
 	}
 
+	// Events and active alarms sharing a timestamp are kept apart by nanosecond offsets
+	// which a coarser precision would truncate, merging them into one point
+	if (events.Enabled || activeAlarms.Enabled) && influx.SeriesPrecision != "ns" {
+		return nil, fmt.Errorf("Influx series-precision must be ns when events or active alarms are enabled")
+	}
+
+	// A UDP listener writes everything it receives to a single database
+	if influx.Protocol == ProtocolUDP {
+		if len(routes) > 0 {
+			return nil, fmt.Errorf("routes cannot be used with the udp Influx protocol")
+		}
+		for _, target := range targets {
+			if target.Database != "" {
+				return nil, fmt.Errorf("target databases cannot be used with the udp Influx protocol")
+			}
+		}
+	}
+
 	return &Config{
-		Application:  *application,
-		Influx:       *influx,
-		Metrics:      *metrics,
-		Target:       *target,
-		AlarmHistory: *alarmHistory,
+		Application:    *application,
+		Influx:         *influx,
+		Metrics:        *metrics,
+		Targets:        targets,
+		Events:         *events,
+		ActiveAlarms:   *activeAlarms,
+		Grafana:        *grafana,
+		Prometheus:     *prometheus,
+		Exporter:       *exporter,
+		Graphite:       *graphite,
+		Inventory:      *inventory,
+		CircuitBreaker: *circuitBreaker,
+		Cache:          *cache,
+		Location:       *location,
+		Tags:           *tags,
+		Routes:         routes,
+		Policies:       policies,
+		Rollups:        *rollups,
 	}, nil
 }
 
 func getApplicationConfig(ini *ini.File) (*ApplicationConfig, error) {
-	applicationConfig := new(ApplicationConfig)
+	// Older versions of the config only bound the number of routers, so default the
+	// request limits to something that won't overload a conductor.
+	applicationConfig := &ApplicationConfig{
+		MaxConcurrentRequests:       20,
+		MaxConcurrentRouterRequests: 4,
+		MetricBatchSize:             1,
+	}
 	err := ini.Section("application").MapTo(applicationConfig)
 	if err != nil {
 		return nil, err
@@ -102,19 +417,358 @@ func getApplicationConfig(ini *ini.File) (*ApplicationConfig, error) {
 	if applicationConfig.MaxConcurrentRouters <= 0 {
 		return nil, fmt.Errorf("Error: The maximum concurrent routers must be greater than 0")
 	}
+	if applicationConfig.MaxConcurrentRequests <= 0 {
+		return nil, fmt.Errorf("the maximum concurrent requests must be greater than 0")
+	}
+	if applicationConfig.MaxConcurrentRouterRequests <= 0 {
+		return nil, fmt.Errorf("the maximum concurrent requests per router must be greater than 0")
+	}
 
 	return applicationConfig, nil
 }
 
-func getAlarmHistoryConfig(ini *ini.File) (*AlarmHistoryConfig, error) {
-	config := new(AlarmHistoryConfig)
-	err := ini.Section("alarm-history").MapTo(config)
+func getEventsConfig(ini *ini.File) (*EventsConfig, error) {
+	config := &EventsConfig{
+		ChunkSize:           3600,
+		PageSize:            1000,
+		AlarmDurationMaxAge: 604800,
+	}
+
+	section, err := ini.GetSection("events")
 	if err != nil {
+		// Older versions of the config only collected alarms within an alarm-history section
+		legacy := ini.Section("alarm-history")
+		config.Enabled = legacy.Key("enabled").MustBool(false)
+		config.QueryTime = legacy.Key("max-query-time").MustInt(0)
+		config.AlarmDurationStateFile = legacy.Key("duration-state-file").String()
+		config.TypeNames = []string{"ALARM"}
+	} else if err = section.MapTo(config); err != nil {
 		return nil, err
 	}
 
+	if !config.Enabled {
+		return config, nil
+	}
+
 	if config.QueryTime <= 0 {
-		return nil, fmt.Errorf("alarms max-query-time must be greater than 0 seconds")
+		return nil, fmt.Errorf("events max-query-time must be greater than 0 seconds")
+	}
+	if config.ChunkSize <= 0 {
+		return nil, fmt.Errorf("events chunk-size must be greater than 0 seconds")
+	}
+	if config.PageSize < 0 {
+		return nil, fmt.Errorf("events page-size cannot be negative")
+	}
+	if config.AlarmDurationMaxAge < 0 {
+		return nil, fmt.Errorf("events alarm-duration-max-age cannot be negative")
+	}
+
+	measurements := make(map[string]string)
+	for _, name := range config.TypeNames {
+		eventType := EventTypeConfig{
+			Type:        name,
+			Measurement: defaultEventMeasurement(name),
+		}
+
+		if section, err := ini.GetSection("events." + name); err == nil {
+			if err := section.MapTo(&eventType); err != nil {
+				return nil, err
+			}
+		}
+
+		eventType.FieldTypes = make(map[string]string)
+		for _, fieldType := range eventType.FieldTypeList {
+			i := strings.LastIndex(fieldType, ":")
+			if i == -1 || !event.IsFieldType(fieldType[i+1:]) {
+				return nil, fmt.Errorf("event %v field type %v must be of the form field:string|integer|float|boolean", name, fieldType)
+			}
+
+			eventType.FieldTypes[fieldType[:i]] = fieldType[i+1:]
+		}
+
+		// Each type's watermark is the last time written to its measurement so they can't be shared
+		if other, ok := measurements[eventType.Measurement]; ok {
+			return nil, fmt.Errorf("event types %v and %v cannot share the measurement %v", other, name, eventType.Measurement)
+		}
+		measurements[eventType.Measurement] = name
+
+		config.Types = append(config.Types, eventType)
+	}
+
+	return config, nil
+}
+
+// defaultEventMeasurement determines the measurement of an event type that doesn't
+// configure one. Alarms keep the name used before other event types were supported.
+func defaultEventMeasurement(eventType string) string {
+	if eventType == "ALARM" {
+		return "alarm-history"
+	}
+
+	return strings.ToLower(eventType) + "-history"
+}
+
+func getActiveAlarmsConfig(ini *ini.File) (*ActiveAlarmsConfig, error) {
+	config := new(ActiveAlarmsConfig)
+	err := ini.Section("active-alarms").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func getInventoryConfig(ini *ini.File) (*InventoryConfig, error) {
+	config := new(InventoryConfig)
+	err := ini.Section("inventory").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func getGrafanaConfig(ini *ini.File) (*GrafanaConfig, error) {
+	config := new(GrafanaConfig)
+	err := ini.Section("grafana").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Enabled && len(config.URL) == 0 {
+		return nil, fmt.Errorf("you must have a Grafana URL set in the configuration file when annotations are enabled")
+	}
+
+	return config, nil
+}
+
+func getPrometheusConfig(ini *ini.File) (*PrometheusConfig, error) {
+	config := &PrometheusConfig{
+		MetricPrefix: "t128_",
+		Retries:      3,
+		Timeout:      30,
+	}
+	err := ini.Section("prometheus").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Enabled && len(config.URL) == 0 {
+		return nil, fmt.Errorf("you must have a Prometheus remote write URL set in the configuration file when Prometheus is enabled")
+	}
+	if config.Retries < 0 || config.Timeout < 0 {
+		return nil, fmt.Errorf("Prometheus retries and timeout cannot be negative")
+	}
+
+	return config, nil
+}
+
+func getExporterConfig(ini *ini.File) (*ExporterConfig, error) {
+	config := &ExporterConfig{
+		Listen:   ":9128",
+		Interval: 60,
+		Lookback: 300,
+	}
+	err := ini.Section("exporter").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Interval < 1 || config.Lookback < 1 {
+		return nil, fmt.Errorf("exporter interval and lookback must be positive")
+	}
+
+	return config, nil
+}
+
+func getGraphiteConfig(ini *ini.File) (*GraphiteConfig, error) {
+	config := &GraphiteConfig{
+		Protocol:   GraphiteProtocolPlaintext,
+		Template:   "t128.{router}.{node}.{metric}.{tags}",
+		BatchSize:  1000,
+		BufferSize: 100000,
+		Timeout:    10,
+	}
+	err := ini.Section("graphite").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Enabled && len(config.Address) == 0 {
+		return nil, fmt.Errorf("you must have a Graphite address set in the configuration file when Graphite is enabled")
+	}
+	if config.Protocol != GraphiteProtocolPlaintext && config.Protocol != GraphiteProtocolPickle {
+		return nil, fmt.Errorf("Graphite protocol must be %v or %v", GraphiteProtocolPlaintext, GraphiteProtocolPickle)
+	}
+	if config.BatchSize < 1 || config.BufferSize < 1 || config.Timeout < 0 {
+		return nil, fmt.Errorf("Graphite batch-size and buffer-size must be positive and timeout cannot be negative")
+	}
+
+	return config, nil
+}
+
+func getCircuitBreakerConfig(ini *ini.File) (*CircuitBreakerConfig, error) {
+	config := &CircuitBreakerConfig{FailureThreshold: 5}
+	err := ini.Section("circuit-breaker").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.FailureThreshold <= 0 {
+		return nil, fmt.Errorf("circuit-breaker failure-threshold must be greater than 0")
+	}
+
+	return config, nil
+}
+
+func getCacheConfig(ini *ini.File) (*CacheConfig, error) {
+	config := &CacheConfig{
+		File:           "influx-importer.cache",
+		MetadataTTL:    86400,
+		PermutationTTL: 3600,
+	}
+	err := ini.Section("cache").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Enabled && len(config.File) == 0 {
+		return nil, fmt.Errorf("you must have a cache file set in the configuration file when the cache is enabled")
+	}
+	if config.MetadataTTL < 0 || config.PermutationTTL < 0 {
+		return nil, fmt.Errorf("cache ttl values cannot be negative")
+	}
+
+	return config, nil
+}
+
+func getLocationConfig(ini *ini.File) (*LocationConfig, error) {
+	config := new(LocationConfig)
+	err := ini.Section("location").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.GeohashPrecision > 12 {
+		return nil, fmt.Errorf("location geohash-precision cannot be greater than 12 characters")
+	}
+
+	return config, nil
+}
+
+func getTagsConfig(ini *ini.File) (*TagsConfig, error) {
+	config := &TagsConfig{
+		Rename: ini.Section("tags.rename").KeysHash(),
+		Static: ini.Section("tags.static").KeysHash(),
+	}
+
+	section := ini.Section("tags")
+	if section.HasKey("drop") {
+		config.Drop = section.Key("drop").Strings(",")
+	}
+
+	const rewritePrefix = "tags.rewrite."
+	for _, child := range section.ChildSections() {
+		if !strings.HasPrefix(child.Name(), rewritePrefix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(child.Name(), rewritePrefix)
+		pattern, err := regexp.Compile(child.Key("pattern").String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag rewrite pattern for %v: %v", key, err)
+		}
+
+		config.Rewrites = append(config.Rewrites, TagRewriteConfig{
+			Key:         key,
+			Pattern:     pattern,
+			Replacement: child.Key("replacement").String(),
+		})
+	}
+
+	return config, nil
+}
+
+func getRouteConfigs(ini *ini.File, events *EventsConfig) ([]RouteConfig, error) {
+	var routes []RouteConfig
+	for _, section := range ini.Section("route").ChildSections() {
+		route := RouteConfig{Name: strings.TrimPrefix(section.Name(), "route.")}
+		if err := section.MapTo(&route); err != nil {
+			return nil, err
+		}
+
+		if route.Database == "" && route.RetentionPolicy == "" {
+			return nil, fmt.Errorf("route %v must set a database or retention-policy", route.Name)
+		}
+
+		for _, pattern := range append(append(route.Metrics, route.Series...), route.Routers...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid route %v pattern %v: %v", route.Name, pattern, err)
+			}
+		}
+
+		// Events are written to the measurement of their type so route that series
+		for _, eventType := range route.Events {
+			measurement := defaultEventMeasurement(eventType)
+			for _, t := range events.Types {
+				if t.Type == eventType {
+					measurement = t.Measurement
+				}
+			}
+
+			route.Series = append(route.Series, measurement)
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+func getRetentionPolicyConfigs(ini *ini.File) ([]RetentionPolicyConfig, error) {
+	var policies []RetentionPolicyConfig
+	defaults := 0
+	for _, section := range ini.Section("retention-policy").ChildSections() {
+		policy := RetentionPolicyConfig{
+			Name:        strings.TrimPrefix(section.Name(), "retention-policy."),
+			Replication: 1,
+		}
+		if err := section.MapTo(&policy); err != nil {
+			return nil, err
+		}
+
+		if !influxDuration.MatchString(policy.Duration) {
+			return nil, fmt.Errorf("retention policy %v must have a duration such as 7d or INF", policy.Name)
+		}
+		if policy.ShardDuration != "" && !influxDuration.MatchString(policy.ShardDuration) {
+			return nil, fmt.Errorf("invalid shard-duration %v for retention policy %v", policy.ShardDuration, policy.Name)
+		}
+		if policy.Replication < 1 {
+			return nil, fmt.Errorf("retention policy %v must have a replication of at least 1", policy.Name)
+		}
+		if policy.Default {
+			defaults++
+		}
+
+		policies = append(policies, policy)
+	}
+
+	if defaults > 1 {
+		return nil, fmt.Errorf("only one retention policy can be the default")
+	}
+
+	return policies, nil
+}
+
+func getRollupsConfig(ini *ini.File) (*RollupsConfig, error) {
+	config := &RollupsConfig{Function: "mean"}
+	err := ini.Section("rollups").MapTo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rollupFunction.MatchString(config.Function) {
+		return nil, fmt.Errorf("invalid rollup function %v", config.Function)
 	}
 
 	return config, nil
@@ -141,12 +795,20 @@ func getMetricsConfig(ini *ini.File) (*MetricsConfig, error) {
 		return nil, fmt.Errorf("metric max-query-time must be greater than 0 seconds")
 	}
 
+	switch metricsConfig.Units {
+	case "":
+		metricsConfig.Units = UnitsNone
+	case UnitsNone, UnitsTag, UnitsField:
+	default:
+		return nil, fmt.Errorf("metric units must be one of %v, %v or %v", UnitsNone, UnitsTag, UnitsField)
+	}
+
 	metricKeys := metricsSection.Keys()
 	metricsConfig.Metrics = make([]string, 0, len(metricKeys))
 	for _, key := range metricKeys {
 		// We must ignore the keys that are reflected upon to erroneously picking them up as
 		// keys to metrics
-		if key.Name() == "max-query-time" {
+		if key.Name() == "max-query-time" || key.Name() == "units" {
 			continue
 		}
 
@@ -156,9 +818,37 @@ func getMetricsConfig(ini *ini.File) (*MetricsConfig, error) {
 	return metricsConfig, nil
 }
 
-func getTargetConfig(ini *ini.File) (*TargetConfig, error) {
-	targetConfig := new(TargetConfig)
-	err := ini.Section("target").MapTo(targetConfig)
+func getTargetConfigs(ini *ini.File) ([]TargetConfig, error) {
+	section := ini.Section("target")
+
+	// Multiple targets are configured within [target.NAME] sections which inherit any
+	// settings from the [target] section. A lone [target] section is an unnamed target.
+	children := section.ChildSections()
+	if len(children) == 0 {
+		target, err := getTargetConfig(section, "")
+		if err != nil {
+			return nil, err
+		}
+
+		return []TargetConfig{*target}, nil
+	}
+
+	targets := make([]TargetConfig, 0, len(children))
+	for _, child := range children {
+		target, err := getTargetConfig(child, strings.TrimPrefix(child.Name(), "target."))
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, *target)
+	}
+
+	return targets, nil
+}
+
+func getTargetConfig(section *ini.Section, name string) (*TargetConfig, error) {
+	targetConfig := &TargetConfig{Name: name}
+	err := section.MapTo(targetConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -169,13 +859,56 @@ func getTargetConfig(ini *ini.File) (*TargetConfig, error) {
 	if len(targetConfig.Token) == 0 {
 		return nil, fmt.Errorf("you must have a 128T token set in the configuration file")
 	}
+	if targetConfig.RequestsPerSecond < 0 || targetConfig.RouterRequestsPerSecond < 0 {
+		return nil, fmt.Errorf("128T requests per second cannot be negative")
+	}
+
+	for _, pattern := range append(targetConfig.Routers, targetConfig.ExcludeRouters...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid router pattern %v: %v", pattern, err)
+		}
+	}
 
 	return targetConfig, nil
 }
 
+// IncludesRouter determines whether a router should be queried based on the target's router filters
+func (t TargetConfig) IncludesRouter(router string) bool {
+	included := len(t.Routers) == 0
+	for _, pattern := range t.Routers {
+		if matched, _ := path.Match(pattern, router); matched {
+			included = true
+			break
+		}
+	}
+
+	for _, pattern := range t.ExcludeRouters {
+		if matched, _ := path.Match(pattern, router); matched {
+			return false
+		}
+	}
+
+	return included
+}
+
 func getInfluxConfig(ini *ini.File) (*InfluxConfig, error) {
-	influxConfig := new(InfluxConfig)
-	err := ini.Section("influx").MapTo(influxConfig)
+	influxConfig := &InfluxConfig{
+		FlushInterval:   1000,
+		BufferSize:      100000,
+		MetricPrecision: "ms",
+		SeriesPrecision: "ns",
+		Protocol:        ProtocolHTTP,
+		UDPPayloadSize:  512,
+		WatermarkFile:   "influx-importer.watermarks",
+	}
+
+	// Influx isn't needed when the metrics are only being exported to Prometheus
+	section, err := ini.GetSection("influx")
+	if err != nil {
+		return influxConfig, nil
+	}
+
+	err = section.MapTo(influxConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -186,6 +919,27 @@ func getInfluxConfig(ini *ini.File) (*InfluxConfig, error) {
 	if len(influxConfig.Database) == 0 {
 		return nil, fmt.Errorf("you must have a Influx database set in the configuration file")
 	}
+	if !contains(influxPrecisions, influxConfig.MetricPrecision) || !contains(influxPrecisions, influxConfig.SeriesPrecision) {
+		return nil, fmt.Errorf("Influx precisions must be one of %v", strings.Join(influxPrecisions, ", "))
+	}
+	if !contains(influxConsistencies, influxConfig.WriteConsistency) {
+		return nil, fmt.Errorf("invalid Influx write-consistency %v", influxConfig.WriteConsistency)
+	}
+	if influxConfig.BatchSize < 0 || influxConfig.FlushInterval < 0 || influxConfig.WriteTimeout < 0 {
+		return nil, fmt.Errorf("Influx batch-size, flush-interval and write-timeout cannot be negative")
+	}
+	if influxConfig.BatchSize > 0 && influxConfig.BufferSize < influxConfig.BatchSize {
+		return nil, fmt.Errorf("Influx buffer-size cannot be less than batch-size")
+	}
+	if influxConfig.Protocol != ProtocolHTTP && influxConfig.Protocol != ProtocolUDP {
+		return nil, fmt.Errorf("Influx protocol must be %v or %v", ProtocolHTTP, ProtocolUDP)
+	}
+	if influxConfig.Protocol == ProtocolUDP && len(influxConfig.WatermarkFile) == 0 {
+		return nil, fmt.Errorf("you must have a watermark file set in the configuration file when using the udp Influx protocol")
+	}
+	if influxConfig.UDPPayloadSize < 1 {
+		return nil, fmt.Errorf("Influx udp-payload-size must be positive")
+	}
 
 	return influxConfig, nil
 }
@@ -196,6 +950,18 @@ func PrintConfig(url string, token string, metrics []*client.MetricDescriptor, o
 	fmt.Fprintln(output, "# The maximum number of routers to query at a given time.")
 	fmt.Fprintln(output, "max-concurrent-routers=10")
 	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The maximum number of requests in flight to the 128T at a given time.")
+	fmt.Fprintln(output, "max-concurrent-requests=20")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The maximum number of requests in flight for a single router at a given time.")
+	fmt.Fprintln(output, "max-concurrent-requests-per-router=4")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# EXPERIMENTAL: the maximum number of metric permutations requested from a router at")
+	fmt.Fprintln(output, "# once through a single GraphQL query. The query hasn't been checked against a")
+	fmt.Fprintln(output, "# documented 128T GraphQL schema, so batching is disabled by default and batches")
+	fmt.Fprintln(output, "# that fail fall back to one request per permutation.")
+	fmt.Fprintln(output, "# metric-batch-size=50")
+	fmt.Fprintln(output)
 	fmt.Fprintln(output, "[target]")
 	fmt.Fprintln(output, "# The fully qualified URL to the 128T Web Instance. E.g: https://10.0.1.29")
 	fmt.Fprintf(output, "url=%v\n", url)
@@ -203,24 +969,285 @@ func PrintConfig(url string, token string, metrics []*client.MetricDescriptor, o
 	fmt.Fprintln(output, "# The JWT token acquired when logging into the 128T application.")
 	fmt.Fprintf(output, "token=%v\n", token)
 	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The maximum requests per second made to the 128T and the number of requests")
+	fmt.Fprintln(output, "# allowed to burst above that rate. A rate of 0 disables the limit.")
+	fmt.Fprintln(output, "requests-per-second=0")
+	fmt.Fprintln(output, "request-burst=1")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The same limits applied to the requests made for each individual router.")
+	fmt.Fprintln(output, "router-requests-per-second=0")
+	fmt.Fprintln(output, "router-request-burst=1")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The routers to query, as a comma separated list of patterns such as \"branch-*\".")
+	fmt.Fprintln(output, "# Leave empty to query every router. Routers matching exclude-routers are skipped.")
+	fmt.Fprintln(output, "routers=")
+	fmt.Fprintln(output, "exclude-routers=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Multiple 128T instances can be queried by moving the settings above into")
+	fmt.Fprintln(output, "# [target.NAME] sections, e.g. [target.east] and [target.west]. Settings left")
+	fmt.Fprintln(output, "# in [target] are shared by every target. Data from each target is tagged with")
+	fmt.Fprintln(output, "# target=NAME and can be written to its own Influx database with database=.")
+	fmt.Fprintln(output)
 	fmt.Fprintln(output, "[influx]")
 	fmt.Fprintln(output, "# The address of the Influx instance which is typically a HTTP address.")
+	fmt.Fprintln(output, "# When using UDP this is the host:port of the UDP listener.")
 	fmt.Fprintln(output, "address=")
 	fmt.Fprintln(output, "username=")
 	fmt.Fprintln(output, "password=")
 	fmt.Fprintln(output, "database=")
 	fmt.Fprintln(output)
-	fmt.Fprintln(output, "[alarm-history]")
-	fmt.Fprintln(output, "# Where alarm history should be collected.")
+	fmt.Fprintln(output, "# A prefix added to the name of every measurement, e.g. \"t128_\".")
+	fmt.Fprintln(output, "measurement-prefix=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# A template naming the measurement of each metric. The replace, lower and upper")
+	fmt.Fprintln(output, "# functions are available, e.g. {{.MetricID | replace \"/\" \"_\"}}. Defaults to the metric ID.")
+	fmt.Fprintln(output, "measurement-template=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# When set, every metric is written to this single measurement with the metric")
+	fmt.Fprintln(output, "# ID as the \"metric\" tag rather than to a measurement per metric.")
+	fmt.Fprintln(output, "wide-measurement=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The number of points buffered across metrics before they're written to Influx.")
+	fmt.Fprintln(output, "# Points are written as soon as they're retrieved when 0.")
+	fmt.Fprintln(output, "# batch-size=0")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The longest time in milliseconds a point is buffered for.")
+	fmt.Fprintln(output, "# flush-interval=1000")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# While Influx can't be written to up to buffer-size points are held and the")
+	fmt.Fprintln(output, "# oldest are dropped beyond that.")
+	fmt.Fprintln(output, "# buffer-size=100000")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The precision of metric points and of other records, such as events. One of")
+	fmt.Fprintln(output, "# ns, u, ms, s, m or h. Events and active alarms are kept apart by offsets of a")
+	fmt.Fprintln(output, "# few nanoseconds so the series precision must be ns while either is enabled.")
+	fmt.Fprintln(output, "# metric-precision=ms")
+	fmt.Fprintln(output, "# series-precision=ns")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Whether writes are gzip compressed.")
+	fmt.Fprintln(output, "# gzip=false")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The write consistency of clustered Influx instances: any, one, quorum or all.")
+	fmt.Fprintln(output, "# write-consistency=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The timeout of each write in milliseconds. No timeout when 0.")
+	fmt.Fprintln(output, "# write-timeout=0")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Either http or udp. Points sent over UDP aren't acknowledged and go to the")
+	fmt.Fprintln(output, "# database of the UDP listener. As UDP can't be queried, the time of the last")
+	fmt.Fprintln(output, "# point written to each series is kept in the watermark file instead.")
+	fmt.Fprintln(output, "# protocol=http")
+	fmt.Fprintln(output, "# udp-payload-size=512")
+	fmt.Fprintln(output, "# watermark-file=influx-importer.watermarks")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[events]")
+	fmt.Fprintln(output, "# Whether audit event history should be collected.")
 	fmt.Fprintln(output, "enabled=true")
 	fmt.Fprintln(output)
-	fmt.Fprintln(output, "# The maximum time, in seconds, to go back and collect alarms for.")
+	fmt.Fprintln(output, "# The maximum time, in seconds, to go back and collect events for.")
 	fmt.Fprintln(output, "max-query-time=3600")
 	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Long time windows are requested in chunks of this many seconds, with each")
+	fmt.Fprintln(output, "# chunk written to Influx before the next is requested.")
+	fmt.Fprintln(output, "chunk-size=3600")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The maximum number of events requested at once. Set to 0 for no limit. A 128T")
+	fmt.Fprintln(output, "# that ignores the limit has each chunk retrieved in a single request.")
+	fmt.Fprintln(output, "page-size=1000")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The audit event types to collect, e.g. ALARM,ADMIN,CONFIG,PROVISIONING.")
+	fmt.Fprintln(output, "# Each type can be customized within an [events.TYPE] section.")
+	fmt.Fprintln(output, "types=ALARM")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The file used to remember open alarms between runs so that raise and clear")
+	fmt.Fprintln(output, "# events can be paired into alarm durations. Leave empty to disable durations.")
+	fmt.Fprintln(output, "alarm-duration-state-file=influx-importer.alarms")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The time, in seconds, an alarm is remembered as open without seeing its clear")
+	fmt.Fprintln(output, "# event. Set to 0 to remember open alarms until they're cleared.")
+	fmt.Fprintln(output, "alarm-duration-max-age=604800")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[events.ALARM]")
+	fmt.Fprintln(output, "# The measurement events of this type are written to.")
+	fmt.Fprintln(output, "measurement=alarm-history")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Nested event data is flattened into dotted keys, e.g. \"source.process\".")
+	fmt.Fprintln(output, "# Event data written as tags in addition to router, node and geohash.")
+	fmt.Fprintln(output, "tags=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Event data written as fields. Leave empty to write all of the event data.")
+	fmt.Fprintln(output, "fields=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The type event data is converted to before being written, e.g. \"count:integer\".")
+	fmt.Fprintln(output, "# Types are string, integer, float and boolean. Numbers default to float.")
+	fmt.Fprintln(output, "field-types=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[active-alarms]")
+	fmt.Fprintln(output, "# Whether a snapshot of the currently active alarms should be collected.")
+	fmt.Fprintln(output, "enabled=true")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[inventory]")
+	fmt.Fprintln(output, "# Whether a snapshot of every router and node, along with its software version")
+	fmt.Fprintln(output, "# and reachability, should be collected.")
+	fmt.Fprintln(output, "enabled=true")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[grafana]")
+	fmt.Fprintln(output, "# Whether raised alarms should also be posted as Grafana annotations.")
+	fmt.Fprintln(output, "enabled=false")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The URL of the Grafana instance and an API key with the Editor role.")
+	fmt.Fprintln(output, "url=")
+	fmt.Fprintln(output, "api-key=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Additional tags added to every annotation.")
+	fmt.Fprintln(output, "tags=128T")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[prometheus]")
+	fmt.Fprintln(output, "# Whether metrics should also be sent to a Prometheus remote write receiver such")
+	fmt.Fprintln(output, "# as Prometheus, Thanos Receive or Mimir.")
+	fmt.Fprintln(output, "enabled=false")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The remote write URL, e.g. http://localhost:9090/api/v1/write")
+	fmt.Fprintln(output, "url=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Credentials sent with each write. A bearer token is used over a username and")
+	fmt.Fprintln(output, "# password. The tenant is sent as the X-Scope-OrgID header.")
+	fmt.Fprintln(output, "# username=")
+	fmt.Fprintln(output, "# password=")
+	fmt.Fprintln(output, "# bearer-token=")
+	fmt.Fprintln(output, "# tenant=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The prefix of every metric name, whether written or served by the exporter.")
+	fmt.Fprintln(output, "# metric-prefix=t128_")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The number of times a failed write is retried and the timeout of each write in seconds.")
+	fmt.Fprintln(output, "# retries=3")
+	fmt.Fprintln(output, "# timeout=30")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[graphite]")
+	fmt.Fprintln(output, "# Whether metrics should also be sent to a Graphite carbon receiver.")
+	fmt.Fprintln(output, "enabled=false")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The host:port of carbon and whether to send with the plaintext or pickle protocol.")
+	fmt.Fprintln(output, "address=")
+	fmt.Fprintln(output, "# protocol=plaintext")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The path of each metric. Placeholders name a tag, {metric} is the metric ID and")
+	fmt.Fprintln(output, "# {tags} is every other tag as key.value pairs. Unsafe characters become \"_\".")
+	fmt.Fprintln(output, "# template=t128.{router}.{node}.{metric}.{tags}")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Datapoints are sent in batches. While carbon is unreachable up to buffer-size")
+	fmt.Fprintln(output, "# datapoints are held and the oldest are dropped beyond that.")
+	fmt.Fprintln(output, "# batch-size=1000")
+	fmt.Fprintln(output, "# buffer-size=100000")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The timeout in seconds of connecting and of each write.")
+	fmt.Fprintln(output, "# timeout=10")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[exporter]")
+	fmt.Fprintln(output, "# Settings of the exporter command, which serves the latest value of every metric")
+	fmt.Fprintln(output, "# for Prometheus to scrape. The [influx] section isn't needed when only exporting.")
+	fmt.Fprintln(output, "# The address metrics are served on at /metrics.")
+	fmt.Fprintln(output, "# listen=:9128")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# How often in seconds the metrics are fetched from the 128T.")
+	fmt.Fprintln(output, "# interval=60")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# How many seconds of each metric to request when looking for its latest value.")
+	fmt.Fprintln(output, "# lookback=300")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[circuit-breaker]")
+	fmt.Fprintln(output, "# Whether routers should be skipped for the rest of a run after repeated failures.")
+	fmt.Fprintln(output, "enabled=true")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The number of consecutive requests that fail to reach a router, through a")
+	fmt.Fprintln(output, "# connection error, timeout or 5xx response, before the router is skipped.")
+	fmt.Fprintln(output, "failure-threshold=5")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The file used to remember skipped routers between runs so that the next run")
+	fmt.Fprintln(output, "# only probes them with a single request. Leave empty to start fresh every run.")
+	fmt.Fprintln(output, "state-file=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[cache]")
+	fmt.Fprintln(output, "# Whether routers, metric metadata and permutations should be cached between runs.")
+	fmt.Fprintln(output, "# The cache is discarded whenever the 128T software version changes.")
+	fmt.Fprintln(output, "enabled=true")
+	fmt.Fprintln(output, "file=influx-importer.cache")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The time, in seconds, before cached metric metadata is fetched again.")
+	fmt.Fprintln(output, "metadata-ttl=86400")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The time, in seconds, before cached routers and metric permutations are fetched again.")
+	fmt.Fprintln(output, "permutations-ttl=3600")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[location]")
+	fmt.Fprintln(output, "# The number of characters of the router's geohash added as a tag to every")
+	fmt.Fprintln(output, "# metric point. Set to 0 to leave the geohash off of metrics.")
+	fmt.Fprintln(output, "geohash-precision=0")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Whether the router's latitude and longitude are added as fields to every metric point.")
+	fmt.Fprintln(output, "coordinates=false")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[tags]")
+	fmt.Fprintln(output, "# Tags to leave off of every point, e.g. \"geohash,network_interface\".")
+	fmt.Fprintln(output, "drop=")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Tags to rename, e.g. \"device_interface=interface\".")
+	fmt.Fprintln(output, "[tags.rename]")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Tags added to every point, e.g. \"env=prod\".")
+	fmt.Fprintln(output, "[tags.static]")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Tag values can be rewritten with a regular expression within a section")
+	fmt.Fprintln(output, "# named after the tag. E.g. to strip a prefix from router names:")
+	fmt.Fprintln(output, "# [tags.rewrite.router]")
+	fmt.Fprintln(output, "# pattern=^corp-(.*)$")
+	fmt.Fprintln(output, "# replacement=$1")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Points can be routed to other databases and retention policies within")
+	fmt.Fprintln(output, "# [route.NAME] sections. The first route matching a point is used and anything")
+	fmt.Fprintln(output, "# unmatched goes to the default retention policy of the Influx database. E.g:")
+	fmt.Fprintln(output, "# [route.bandwidth]")
+	fmt.Fprintln(output, "# metrics=bandwidth*")
+	fmt.Fprintln(output, "# retention-policy=7d")
+	fmt.Fprintln(output, "#")
+	fmt.Fprintln(output, "# [route.alarms]")
+	fmt.Fprintln(output, "# events=ALARM")
+	fmt.Fprintln(output, "# series=alarm-duration,active-alarms,active-alarm-count")
+	fmt.Fprintln(output, "# retention-policy=1y")
+	fmt.Fprintln(output, "#")
+	fmt.Fprintln(output, "# Routes can also be limited to routers with routers=, and write to another")
+	fmt.Fprintln(output, "# database with database=.")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# Retention policies created by the influx-setup command are configured within")
+	fmt.Fprintln(output, "# [retention-policy.NAME] sections. E.g:")
+	fmt.Fprintln(output, "# [retention-policy.7d]")
+	fmt.Fprintln(output, "# duration=7d")
+	fmt.Fprintln(output, "# shard-duration=1d")
+	fmt.Fprintln(output, "# replication=1")
+	fmt.Fprintln(output, "# default=false")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "[rollups]")
+	fmt.Fprintln(output, "# Whether the influx-setup command should install continuous queries that")
+	fmt.Fprintln(output, "# downsample every metric into hourly and daily measurements, e.g. bandwidth_1h.")
+	fmt.Fprintln(output, "enabled=false")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The aggregate function applied to each field of a metric.")
+	fmt.Fprintln(output, "# function=mean")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# The retention policies the rollups are written to. Defaults to the database's")
+	fmt.Fprintln(output, "# default retention policy.")
+	fmt.Fprintln(output, "# hourly-retention-policy=")
+	fmt.Fprintln(output, "# daily-retention-policy=")
+	fmt.Fprintln(output)
 	fmt.Fprintln(output, "[metrics]")
 	fmt.Fprintln(output, "# The maximum time, in seconds, to go back and collect metrics for.")
 	fmt.Fprintln(output, "max-query-time=3600")
 	fmt.Fprintln(output)
+	fmt.Fprintln(output, "# How a metric's units are written: \"none\", \"tag\" for a units tag, or \"field\"")
+	fmt.Fprintln(output, "# to name the value field after them, e.g. value_bps.")
+	fmt.Fprintln(output, "units=none")
+	fmt.Fprintln(output)
 	fmt.Fprintln(output, "# All metrics are, by default, disabled.")
 	fmt.Fprintln(output, "# Uncomment the desired stat to begin pulling for it.")
 	fmt.Fprintln(output, "# Keep in mind that the more stats you enable the longer query times take")
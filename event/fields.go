@@ -0,0 +1,160 @@
+package event
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// The types a field can be coerced to
+const (
+	String  = "string"
+	Integer = "integer"
+	Float   = "float"
+	Boolean = "boolean"
+)
+
+// IsFieldType determines whether a type name can be coerced to
+func IsFieldType(fieldType string) bool {
+	switch fieldType {
+	case String, Integer, Float, Boolean:
+		return true
+	}
+
+	return false
+}
+
+// Flatten converts nested maps and arrays into a single level map. The keys of nested
+// values are joined with dots and array elements are keyed by their index, so
+// {"a": {"b": [1, 2]}} becomes {"a.b.0": 1, "a.b.1": 2}. Nil values are dropped.
+func Flatten(data map[string]interface{}) map[string]interface{} {
+	flattened := make(map[string]interface{})
+	flatten("", data, flattened)
+	return flattened
+}
+
+func flatten(prefix string, value interface{}, flattened map[string]interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		for k, child := range v {
+			flatten(join(prefix, k), child, flattened)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flatten(join(prefix, strconv.Itoa(i)), child, flattened)
+		}
+	default:
+		flattened[prefix] = v
+	}
+}
+
+func join(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+// Coerce converts a value to the given field type. An empty type keeps the value's
+// natural type, which is a float for every JSON number.
+func Coerce(value interface{}, fieldType string) (interface{}, error) {
+	switch fieldType {
+	case "":
+		switch v := value.(type) {
+		case string, float64, bool:
+			return v, nil
+		}
+		return nil, fmt.Errorf("unsupported type %T", value)
+	case String:
+		return fmt.Sprint(value), nil
+	case Integer:
+		switch v := value.(type) {
+		case float64:
+			// Truncating would silently write a different value
+			if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+				return nil, fmt.Errorf("%v is not an integer", v)
+			}
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		case bool:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+	case Float:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case string:
+			return strconv.ParseFloat(v, 64)
+		case bool:
+			if v {
+				return float64(1), nil
+			}
+			return float64(0), nil
+		}
+	case Boolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		case float64:
+			return v != 0, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot convert %T to %v", value, fieldType)
+}
+
+// Rejection describes an event value that couldn't be written
+type Rejection struct {
+	Key    string
+	Value  interface{}
+	Reason error
+}
+
+// Fields splits flattened event data into tags and fields. Keys listed in tags are
+// promoted to tags, the remaining keys are written as fields if allowed (an empty
+// allowlist allows everything) after being coerced to their configured type. Values
+// that can't be coerced are returned as rejections rather than failing the event.
+func Fields(data map[string]interface{}, tags map[string]bool, allowed map[string]bool, types map[string]string) (map[string]string, map[string]interface{}, []Rejection) {
+	tagValues := make(map[string]string)
+	fieldValues := make(map[string]interface{})
+	var rejections []Rejection
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value := data[k]
+
+		if tags[k] {
+			tagValues[k] = fmt.Sprint(value)
+			continue
+		}
+
+		if len(allowed) != 0 && !allowed[k] {
+			continue
+		}
+
+		coerced, err := Coerce(value, types[k])
+		if err != nil {
+			rejections = append(rejections, Rejection{Key: k, Value: value, Reason: err})
+			continue
+		}
+
+		fieldValues[k] = coerced
+	}
+
+	return tagValues, fieldValues, rejections
+}
@@ -0,0 +1,97 @@
+package event
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	got := Flatten(map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{float64(1), "two"},
+			"c": nil,
+		},
+		"d": true,
+		"e": []interface{}{map[string]interface{}{"f": "g"}},
+	})
+
+	want := map[string]interface{}{
+		"a.b.0": float64(1),
+		"a.b.1": "two",
+		"d":     true,
+		"e.0.f": "g",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Flatten() = %v, want %v", got, want)
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		value     interface{}
+		fieldType string
+		want      interface{}
+		fails     bool
+	}{
+		{float64(1.5), "", float64(1.5), false},
+		{"text", "", "text", false},
+		{[]interface{}{}, "", nil, true},
+		{float64(1.5), String, "1.5", false},
+		{true, String, "true", false},
+		{float64(3), Integer, int64(3), false},
+		{float64(-3), Integer, int64(-3), false},
+		{float64(1.7), Integer, nil, true},
+		{float64(1e19), Integer, nil, true},
+		{"42", Integer, int64(42), false},
+		{"4.2", Integer, nil, true},
+		{true, Integer, int64(1), false},
+		{float64(2), Float, float64(2), false},
+		{"2.5", Float, float64(2.5), false},
+		{"x", Float, nil, true},
+		{false, Float, float64(0), false},
+		{"true", Boolean, true, false},
+		{float64(0), Boolean, false, false},
+		{"maybe", Boolean, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := Coerce(test.value, test.fieldType)
+		if (err != nil) != test.fails {
+			t.Errorf("Coerce(%#v, %q) = %v, want failure %v", test.value, test.fieldType, err, test.fails)
+			continue
+		}
+		if !test.fails && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Coerce(%#v, %q) = %#v, want %#v", test.value, test.fieldType, got, test.want)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	data := map[string]interface{}{
+		"node":     "east-a",
+		"severity": "MAJOR",
+		"count":    float64(2.5),
+		"shelved":  "false",
+		"ignored":  "x",
+	}
+	tags := map[string]bool{"node": true}
+	allowed := map[string]bool{"severity": true, "count": true, "shelved": true}
+	types := map[string]string{"count": Integer, "shelved": Boolean}
+
+	gotTags, gotFields, gotRejections := Fields(data, tags, allowed, types)
+
+	if want := map[string]string{"node": "east-a"}; !reflect.DeepEqual(gotTags, want) {
+		t.Errorf("tags = %v, want %v", gotTags, want)
+	}
+	if want := map[string]interface{}{"severity": "MAJOR", "shelved": false}; !reflect.DeepEqual(gotFields, want) {
+		t.Errorf("fields = %v, want %v", gotFields, want)
+	}
+	if len(gotRejections) != 1 || gotRejections[0].Key != "count" || gotRejections[0].Value != float64(2.5) {
+		t.Errorf("rejections = %+v, want count=2.5", gotRejections)
+	}
+
+	// Without an allowlist every value is written
+	if _, fields, _ := Fields(data, tags, nil, nil); len(fields) != 4 {
+		t.Errorf("fields without an allowlist = %v, want 4 values", fields)
+	}
+}
@@ -0,0 +1,76 @@
+package grafana
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client represents a connection to the Grafana HTTP API
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	apiKey     string
+}
+
+// Annotation represents an event overlaid on Grafana graphs
+type Annotation struct {
+	Time int64    `json:"time"`
+	Tags []string `json:"tags"`
+	Text string   `json:"text"`
+}
+
+// CreateClient creates a Grafana client given the Grafana URL and an API key with
+// permission to create annotations
+func CreateClient(baseURL string, apiKey string) *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+	}
+}
+
+// NewAnnotation creates an annotation at the given time
+func NewAnnotation(t time.Time, text string, tags []string) Annotation {
+	return Annotation{
+		Time: t.UnixNano() / int64(time.Millisecond),
+		Tags: tags,
+		Text: text,
+	}
+}
+
+// Annotate posts an annotation to Grafana
+func (client *Client) Annotate(annotation Annotation) error {
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%v/api/annotations", client.baseURL)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if client.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+client.apiKey)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return errors.New("Invalid status code: " + resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package grafana
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnnotate(t *testing.T) {
+	var got Annotation
+	var method, path, auth, contentType string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		auth, contentType = r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("unable to decode annotation: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	raised := time.Date(2019, 3, 14, 15, 9, 26, 535000000, time.UTC)
+	client := CreateClient(server.URL+"/", "secret")
+	err := client.Annotate(NewAnnotation(raised, "[CRITICAL] Peer is down", []string{"router:east", "node:east-a"}))
+	if err != nil {
+		t.Fatalf("Annotate() = %v", err)
+	}
+
+	if method != "POST" || path != "/api/annotations" {
+		t.Errorf("request = %v %v, want POST /api/annotations", method, path)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+
+	want := Annotation{
+		Time: 1552576166535,
+		Tags: []string{"router:east", "node:east-a"},
+		Text: "[CRITICAL] Peer is down",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("annotation = %+v, want %+v", got, want)
+	}
+}
+
+func TestAnnotateWithoutAPIKey(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	if err := CreateClient(server.URL, "").Annotate(NewAnnotation(time.Now(), "text", nil)); err != nil {
+		t.Fatalf("Annotate() = %v", err)
+	}
+	if auth != "" {
+		t.Errorf("Authorization = %q, want none", auth)
+	}
+}
+
+func TestAnnotateFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	if err := CreateClient(server.URL, "bad").Annotate(NewAnnotation(time.Now(), "text", nil)); err == nil {
+		t.Error("Annotate() succeeded despite a 401 response")
+	}
+}
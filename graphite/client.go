@@ -0,0 +1,196 @@
+package graphite
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	t128 "github.com/128technology/influx-importer/client"
+	"github.com/128technology/influx-importer/config"
+	"github.com/128technology/influx-importer/logger"
+)
+
+// pickleChunkSize bounds the number of datapoints within a single pickle message
+const pickleChunkSize = 500
+
+type datapoint struct {
+	path      string
+	value     float64
+	timestamp int64
+}
+
+// Client represents a TCP connection to a Graphite carbon receiver. Datapoints are
+// buffered and sent in batches. While carbon is unreachable they're held, up to the
+// buffer size, and sent once a connection can be made again.
+type Client struct {
+	address    string
+	protocol   string
+	template   *Template
+	batchSize  int
+	bufferSize int
+	timeout    time.Duration
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	pending []datapoint
+}
+
+// CreateClient creates a Graphite client sending with one of the config.GraphiteProtocol
+// protocols. The connection is made when the first batch is sent.
+func CreateClient(address string, protocol string, template *Template, batchSize int, bufferSize int, timeout time.Duration) *Client {
+	if bufferSize < batchSize {
+		bufferSize = batchSize
+	}
+
+	return &Client{
+		address:    address,
+		protocol:   protocol,
+		template:   template,
+		batchSize:  batchSize,
+		bufferSize: bufferSize,
+		timeout:    timeout,
+	}
+}
+
+// Send queues the points of a metric permutation and sends them once a batch is ready
+func (client *Client) Send(metric string, tags map[string]string, points []t128.AnalyticPoint) error {
+	path := client.template.Path(metric, tags)
+
+	datapoints := make([]datapoint, 0, len(points))
+	for _, point := range points {
+		timestamp, err := time.Parse(time.RFC3339, point.Time)
+		if err != nil {
+			return err
+		}
+
+		datapoints = append(datapoints, datapoint{path: path, value: point.Value, timestamp: timestamp.Unix()})
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.pending = append(client.pending, datapoints...)
+	if dropped := len(client.pending) - client.bufferSize; dropped > 0 {
+		logger.Log.Warn("Graphite buffer is full. Dropping the oldest %v datapoints.\n", dropped)
+		client.pending = client.pending[dropped:]
+	}
+
+	if len(client.pending) < client.batchSize {
+		return nil
+	}
+
+	return client.flush()
+}
+
+// Flush sends every buffered datapoint
+func (client *Client) Flush() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.flush()
+}
+
+// Close sends every buffered datapoint and closes the connection
+func (client *Client) Close() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	err := client.flush()
+	client.disconnect()
+	return err
+}
+
+func (client *Client) flush() error {
+	if len(client.pending) == 0 {
+		return nil
+	}
+
+	// Each message is a whole line or pickle so that one cut short by a failed write
+	// can be sent again in full
+	var payload []byte
+	var ends, counts []int
+	if client.protocol == config.GraphiteProtocolPickle {
+		for start := 0; start < len(client.pending); start += pickleChunkSize {
+			end := start + pickleChunkSize
+			if end > len(client.pending) {
+				end = len(client.pending)
+			}
+			payload = append(payload, pickleDatapoints(client.pending[start:end])...)
+			ends = append(ends, len(payload))
+			counts = append(counts, end-start)
+		}
+	} else {
+		var b bytes.Buffer
+		for _, dp := range client.pending {
+			fmt.Fprintf(&b, "%v %v %v\n", dp.path, strconv.FormatFloat(dp.value, 'f', -1, 64), dp.timestamp)
+			ends = append(ends, b.Len())
+			counts = append(counts, 1)
+		}
+		payload = b.Bytes()
+	}
+
+	written, err := client.write(payload)
+	if err != nil {
+		// The messages written in full are sent and the rest stay pending for the next
+		// flush, on a fresh connection as carbon discards what was cut short
+		client.disconnect()
+
+		sent := 0
+		for i := 0; i < len(ends) && ends[i] <= written; i++ {
+			sent += counts[i]
+		}
+		client.pending = client.pending[sent:]
+
+		return fmt.Errorf("unable to send %v datapoints to graphite: %v", len(client.pending), err)
+	}
+
+	client.pending = nil
+	return nil
+}
+
+// write writes the payload, returning how much of it was written
+func (client *Client) write(payload []byte) (int, error) {
+	if client.conn != nil && closed(client.conn) {
+		client.disconnect()
+	}
+
+	if client.conn == nil {
+		conn, err := net.DialTimeout("tcp", client.address, client.timeout)
+		if err != nil {
+			return 0, err
+		}
+		client.conn = conn
+	}
+
+	if client.timeout > 0 {
+		client.conn.SetWriteDeadline(time.Now().Add(client.timeout))
+	}
+
+	return client.conn.Write(payload)
+}
+
+// closed determines whether carbon has closed the connection. Carbon never sends
+// anything, so a read that doesn't simply time out means the connection is gone. Writing
+// to such a connection would otherwise succeed while the datapoints are silently lost.
+func closed(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return false
+	}
+
+	return true
+}
+
+func (client *Client) disconnect() {
+	if client.conn != nil {
+		client.conn.Close()
+		client.conn = nil
+	}
+}
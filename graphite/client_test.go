@@ -0,0 +1,441 @@
+package graphite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	t128 "github.com/128technology/influx-importer/client"
+	"github.com/128technology/influx-importer/config"
+)
+
+// carbon is a stub carbon receiver recording everything it's sent
+type carbon struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	conns    []net.Conn
+	received bytes.Buffer
+}
+
+func startCarbon(t *testing.T) *carbon {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	c := &carbon{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			c.mutex.Lock()
+			c.conns = append(c.conns, conn)
+			c.mutex.Unlock()
+
+			go c.read(conn)
+		}
+	}()
+
+	return c
+}
+
+func (c *carbon) read(conn net.Conn) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		c.mutex.Lock()
+		c.received.Write(buf[:n])
+		c.mutex.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *carbon) address() string {
+	return c.listener.Addr().String()
+}
+
+// closeConns closes every accepted connection, as carbon does when it restarts
+func (c *carbon) closeConns() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+func (c *carbon) close() {
+	c.listener.Close()
+	c.closeConns()
+}
+
+func (c *carbon) accepted() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.conns)
+}
+
+// wait waits for the receiver to have been sent n bytes and returns them
+func (c *carbon) wait(t *testing.T, n int) []byte {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mutex.Lock()
+		received := append([]byte(nil), c.received.Bytes()...)
+		c.mutex.Unlock()
+
+		if len(received) >= n || time.Now().After(deadline) {
+			return received
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// points creates count points a minute apart with values counting up from 0
+func points(count int) []t128.AnalyticPoint {
+	start := time.Date(2019, 3, 14, 15, 0, 0, 0, time.UTC)
+
+	pts := make([]t128.AnalyticPoint, count)
+	for i := range pts {
+		pts[i] = t128.AnalyticPoint{
+			Value: float64(i),
+			Time:  start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339),
+		}
+	}
+	return pts
+}
+
+func mustParseTemplate(t *testing.T, text string) *Template {
+	template, err := ParseTemplate(text)
+	if err != nil {
+		t.Fatalf("ParseTemplate(%q) = %v", text, err)
+	}
+	return template
+}
+
+func TestPlaintext(t *testing.T) {
+	c := startCarbon(t)
+	defer c.close()
+
+	client := CreateClient(c.address(), config.GraphiteProtocolPlaintext, mustParseTemplate(t, "t128.{router}.{metric}"), 3, 10, time.Second)
+	defer client.Close()
+
+	pts := points(3)
+	pts[1].Value = 2.5
+	if err := client.Send("cpu/utilization", map[string]string{"router": "east"}, pts); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	want := "t128.east.cpu.utilization 0 1552575600\n" +
+		"t128.east.cpu.utilization 2.5 1552575660\n" +
+		"t128.east.cpu.utilization 2 1552575720\n"
+	if got := string(c.wait(t, len(want))); got != want {
+		t.Errorf("carbon received %q, want %q", got, want)
+	}
+}
+
+func TestSendHoldsPartialBatches(t *testing.T) {
+	c := startCarbon(t)
+	defer c.close()
+
+	client := CreateClient(c.address(), config.GraphiteProtocolPlaintext, mustParseTemplate(t, "{metric}"), 10, 10, time.Second)
+	if err := client.Send("cpu", nil, points(2)); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if c.accepted() != 0 {
+		t.Errorf("a partial batch was sent before being flushed")
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	want := "cpu 0 1552575600\ncpu 1 1552575660\n"
+	if got := string(c.wait(t, len(want))); got != want {
+		t.Errorf("carbon received %q, want %q", got, want)
+	}
+}
+
+// unpickle decodes a list of (path, (timestamp, value)) tuples using only the opcodes
+// carbon's receiver is sent
+func unpickle(b []byte) ([]datapoint, error) {
+	var stack []interface{}
+	var marks []int
+
+	for i := 0; i < len(b); {
+		op := b[i]
+		i++
+
+		switch op {
+		case pickleProto:
+			i++
+		case pickleEmptyList:
+			stack = append(stack, []datapoint{})
+		case pickleMark:
+			marks = append(marks, len(stack))
+		case pickleBinUnicode:
+			size := int(binary.LittleEndian.Uint32(b[i:]))
+			stack = append(stack, string(b[i+4:i+4+size]))
+			i += 4 + size
+		case pickleLong1:
+			size := int(b[i])
+			var v int64
+			for j := size - 1; j >= 0; j-- {
+				v = v<<8 | int64(b[i+1+j])
+			}
+			if size > 0 && b[i+size]&0x80 != 0 {
+				v -= 1 << uint(8*size)
+			}
+			stack = append(stack, v)
+			i += 1 + size
+		case pickleBinFloat:
+			stack = append(stack, math.Float64frombits(binary.BigEndian.Uint64(b[i:])))
+			i += 8
+		case pickleTuple2:
+			n := len(stack)
+			stack = append(stack[:n-2], [2]interface{}{stack[n-2], stack[n-1]})
+		case pickleAppends:
+			mark := marks[len(marks)-1]
+			marks = marks[:len(marks)-1]
+
+			list := stack[mark-1].([]datapoint)
+			for _, item := range stack[mark:] {
+				outer := item.([2]interface{})
+				inner := outer[1].([2]interface{})
+				list = append(list, datapoint{
+					path:      outer[0].(string),
+					timestamp: inner[0].(int64),
+					value:     inner[1].(float64),
+				})
+			}
+			stack = append(stack[:mark-1], list)
+		case pickleStop:
+			if len(stack) != 1 || i != len(b) {
+				return nil, fmt.Errorf("unexpected stop")
+			}
+			return stack[0].([]datapoint), nil
+		default:
+			return nil, fmt.Errorf("unexpected opcode %x", op)
+		}
+	}
+
+	return nil, fmt.Errorf("missing stop")
+}
+
+func TestPickle(t *testing.T) {
+	c := startCarbon(t)
+	defer c.close()
+
+	count := 2*pickleChunkSize + 200
+	client := CreateClient(c.address(), config.GraphiteProtocolPickle, mustParseTemplate(t, "t128.{router}.{metric}"), count, count, time.Second)
+	defer client.Close()
+
+	if err := client.Send("cpu/utilization", map[string]string{"router": "east"}, points(count)); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	var want []datapoint
+	for i := 0; i < count; i++ {
+		want = append(want, datapoint{path: "t128.east.cpu.utilization", value: float64(i), timestamp: 1552575600 + int64(60*i)})
+	}
+
+	// Every message is its length followed by that many bytes of pickle
+	var chunks []int
+	var got []datapoint
+	for offset := 0; len(got) < count; {
+		received := c.wait(t, offset+4)
+		if len(received) < offset+4 {
+			t.Fatalf("carbon received a truncated length prefix after %v datapoints", len(got))
+		}
+
+		size := int(binary.BigEndian.Uint32(received[offset:]))
+		received = c.wait(t, offset+4+size)
+		if len(received) < offset+4+size {
+			t.Fatalf("carbon received a truncated message of %v bytes, want %v", len(received)-offset-4, size)
+		}
+
+		datapoints, err := unpickle(received[offset+4 : offset+4+size])
+		if err != nil {
+			t.Fatalf("unable to unpickle message %v: %v", len(chunks), err)
+		}
+
+		chunks = append(chunks, len(datapoints))
+		got = append(got, datapoints...)
+		offset += 4 + size
+	}
+
+	if want := []int{pickleChunkSize, pickleChunkSize, 200}; !reflect.DeepEqual(chunks, want) {
+		t.Errorf("messages held %v datapoints, want %v", chunks, want)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unpickled datapoints differ from those sent")
+	}
+}
+
+func TestPickleDatapoints(t *testing.T) {
+	want := []datapoint{
+		{path: "a", value: -1.5, timestamp: -129},
+		{path: "b", value: 0, timestamp: 0},
+		{path: "c", value: 1e10, timestamp: 1 << 40},
+	}
+
+	message := pickleDatapoints(want)
+	if size := int(binary.BigEndian.Uint32(message)); size != len(message)-4 {
+		t.Errorf("length prefix = %v, want %v", size, len(message)-4)
+	}
+
+	got, err := unpickle(message[4:])
+	if err != nil {
+		t.Fatalf("unpickle() = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unpickle() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	c := startCarbon(t)
+	defer c.close()
+
+	client := CreateClient(c.address(), config.GraphiteProtocolPlaintext, mustParseTemplate(t, "{metric}"), 1, 10, time.Second)
+	defer client.Close()
+
+	if err := client.Send("first", nil, points(1)); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	first := "first 0 1552575600\n"
+	c.wait(t, len(first))
+
+	// Carbon restarting closes the connection out from under the client
+	c.closeConns()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := client.Send("second", nil, points(1)); err != nil {
+		t.Fatalf("Send() after carbon closed the connection = %v", err)
+	}
+
+	want := first + "second 0 1552575600\n"
+	if got := string(c.wait(t, len(want))); got != want {
+		t.Errorf("carbon received %q, want %q", got, want)
+	}
+	if c.accepted() != 2 {
+		t.Errorf("carbon accepted %v connections, want 2", c.accepted())
+	}
+}
+
+// brokenConn is a connection that fails after writing limit bytes
+type brokenConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *brokenConn) Read(b []byte) (int, error)       { return 0, timeoutError{} }
+func (c *brokenConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *brokenConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *brokenConn) Close() error                     { return nil }
+func (c *brokenConn) Write(b []byte) (int, error)      { return c.limit, fmt.Errorf("connection reset") }
+func (c *brokenConn) RemoteAddr() net.Addr             { return nil }
+func (c *brokenConn) LocalAddr() net.Addr              { return nil }
+func (c *brokenConn) SetDeadline(t time.Time) error    { return nil }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestPartialWrite(t *testing.T) {
+	c := startCarbon(t)
+	defer c.close()
+
+	client := CreateClient(c.address(), config.GraphiteProtocolPlaintext, mustParseTemplate(t, "{metric}"), 3, 10, time.Second)
+	defer client.Close()
+
+	// The connection fails partway through the second line
+	first := "cpu 0 1552575600\n"
+	client.conn = &brokenConn{limit: len(first) + 5}
+	if err := client.Send("cpu", nil, points(3)); err == nil {
+		t.Fatalf("Send() succeeded despite the write failing")
+	}
+	if c.accepted() != 0 {
+		t.Errorf("the failed write was retried straight away")
+	}
+
+	// Only the lines that weren't written in full are sent again
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	want := "cpu 1 1552575660\ncpu 2 1552575720\n"
+	if got := string(c.wait(t, len(want))); got != want {
+		t.Errorf("carbon received %q, want %q", got, want)
+	}
+}
+
+func TestBufferOverflow(t *testing.T) {
+	// Find an address nothing is listening on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := CreateClient(address, config.GraphiteProtocolPlaintext, mustParseTemplate(t, "{metric}"), 2, 3, 100*time.Millisecond)
+
+	// Each full batch fails to send and stays buffered
+	pts := points(5)
+	if err := client.Send("cpu", nil, pts[:2]); err == nil {
+		t.Fatalf("Send() succeeded without a receiver")
+	}
+	if err := client.Send("cpu", nil, pts[2:]); err == nil {
+		t.Fatalf("Send() succeeded without a receiver")
+	}
+
+	var values []float64
+	for _, dp := range client.pending {
+		values = append(values, dp.value)
+	}
+	if want := []float64{2, 3, 4}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("buffered values = %v, want the newest %v", values, want)
+	}
+
+	// Once carbon is back the buffered datapoints are sent
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("unable to listen on %v again: %v", address, err)
+	}
+	c := &carbon{listener: listener}
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			c.mutex.Lock()
+			c.conns = append(c.conns, conn)
+			c.mutex.Unlock()
+			c.read(conn)
+		}
+	}()
+	defer c.close()
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	want := "cpu 2 1552575720\ncpu 3 1552575780\ncpu 4 1552575840\n"
+	if got := string(c.wait(t, len(want))); got != want {
+		t.Errorf("carbon received %q, want %q", got, want)
+	}
+}
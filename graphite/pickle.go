@@ -0,0 +1,68 @@
+package graphite
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Pickle opcodes used to encode datapoints. Carbon's pickle receiver unpickles a list
+// of (path, (timestamp, value)) tuples.
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinFloat   = 'G'
+	pickleLong1      = 0x8a
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+)
+
+// pickleDatapoints encodes datapoints with pickle protocol 2 and prefixes the message
+// with its length as carbon expects
+func pickleDatapoints(datapoints []datapoint) []byte {
+	b := []byte{0, 0, 0, 0, pickleProto, 2, pickleEmptyList, pickleMark}
+
+	for _, dp := range datapoints {
+		b = append(b, pickleBinUnicode)
+		b = appendUint32(b, uint32(len(dp.path)))
+		b = append(b, dp.path...)
+
+		b = appendLong(b, dp.timestamp)
+
+		b = append(b, pickleBinFloat)
+		b = append(b, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(b[len(b)-8:], math.Float64bits(dp.value))
+
+		b = append(b, pickleTuple2, pickleTuple2)
+	}
+
+	b = append(b, pickleAppends, pickleStop)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	b = append(b, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], v)
+	return b
+}
+
+// appendLong encodes an integer as a little endian two's complement LONG1
+func appendLong(b []byte, v int64) []byte {
+	var encoded []byte
+	for {
+		encoded = append(encoded, byte(v))
+		v >>= 8
+
+		// stop once the remaining bits are only the sign extension of the last byte
+		last := encoded[len(encoded)-1]
+		if (v == 0 && last&0x80 == 0) || (v == -1 && last&0x80 != 0) {
+			break
+		}
+	}
+
+	b = append(b, pickleLong1, byte(len(encoded)))
+	return append(b, encoded...)
+}
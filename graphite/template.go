@@ -0,0 +1,88 @@
+package graphite
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Template builds Graphite paths from a metric and its tags. Placeholders name a tag,
+// e.g. "t128.{router}.{node}.{metric}". {metric} is the metric ID with each of its
+// parts becoming a node of the path and {tags} is every tag not otherwise used as
+// sorted key.value pairs. Placeholders for missing tags are left out of the path.
+type Template struct {
+	text       string
+	referenced map[string]bool
+}
+
+var (
+	placeholder = regexp.MustCompile(`\{([^{}]*)\}`)
+	unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+)
+
+// ParseTemplate parses a path template
+func ParseTemplate(text string) (*Template, error) {
+	t := &Template{text: text, referenced: make(map[string]bool)}
+
+	for _, match := range placeholder.FindAllStringSubmatch(text, -1) {
+		if match[1] == "" {
+			return nil, fmt.Errorf("empty placeholder in graphite template %v", text)
+		}
+		t.referenced[match[1]] = true
+	}
+
+	if strings.ContainsAny(placeholder.ReplaceAllString(text, ""), "{}") {
+		return nil, fmt.Errorf("unbalanced braces in graphite template %v", text)
+	}
+
+	return t, nil
+}
+
+// escape makes a value safe to use as a single node of a path
+func escape(value string) string {
+	return strings.Trim(unsafeChars.ReplaceAllString(value, "_"), "_")
+}
+
+// Path renders the path of a metric permutation
+func (t *Template) Path(metric string, tags map[string]string) string {
+	path := placeholder.ReplaceAllStringFunc(t.text, func(match string) string {
+		name := match[1 : len(match)-1]
+
+		switch name {
+		case "metric":
+			parts := strings.Split(metric, "/")
+			for i, part := range parts {
+				parts[i] = escape(part)
+			}
+			return strings.Join(parts, ".")
+		case "tags":
+			keys := make([]string, 0, len(tags))
+			for k := range tags {
+				if !t.referenced[k] {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+
+			parts := make([]string, 0, 2*len(keys))
+			for _, k := range keys {
+				parts = append(parts, escape(k), escape(tags[k]))
+			}
+			return strings.Join(parts, ".")
+		default:
+			return escape(tags[name])
+		}
+	})
+
+	// Drop the empty nodes left by missing tags
+	nodes := strings.Split(path, ".")
+	kept := nodes[:0]
+	for _, node := range nodes {
+		if node != "" {
+			kept = append(kept, node)
+		}
+	}
+
+	return strings.Join(kept, ".")
+}
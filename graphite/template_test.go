@@ -0,0 +1,81 @@
+package graphite
+
+import "testing"
+
+func TestTemplatePath(t *testing.T) {
+	tests := []struct {
+		template string
+		metric   string
+		tags     map[string]string
+		want     string
+	}{
+		{
+			"t128.{router}.{node}.{metric}",
+			"aggregate-session/node/bandwidth",
+			map[string]string{"router": "east", "node": "east-a"},
+			"t128.east.east-a.aggregate-session.node.bandwidth",
+		},
+		{
+			"t128.{router}.{node}.{metric}",
+			"cpu/utilization",
+			map[string]string{"router": "east"},
+			"t128.east.cpu.utilization",
+		},
+		{
+			"{missing}.{metric}",
+			"cpu",
+			nil,
+			"cpu",
+		},
+		{
+			"t128.{router}.{metric}.{tags}",
+			"interface/received",
+			map[string]string{"router": "east", "port": "1", "device-interface": "wan"},
+			"t128.east.interface.received.device-interface.wan.port.1",
+		},
+		{
+			"t128.{metric}.{tags}",
+			"cpu",
+			map[string]string{},
+			"t128.cpu",
+		},
+		{
+			"t128.{router}.{metric}",
+			"/stats/cpu//utilization/",
+			map[string]string{"router": "east.coast router/1"},
+			"t128.east_coast_router_1.stats.cpu.utilization",
+		},
+		{
+			"t128.{router}.{metric}",
+			"cpu",
+			map[string]string{"router": "...***..."},
+			"t128.cpu",
+		},
+		{
+			"t128.{metric}.{tags}",
+			"cpu",
+			map[string]string{"a.b": "c d", "e": ""},
+			"t128.cpu.a_b.c_d.e",
+		},
+	}
+
+	for _, test := range tests {
+		template, err := ParseTemplate(test.template)
+		if err != nil {
+			t.Errorf("ParseTemplate(%q) = %v", test.template, err)
+			continue
+		}
+
+		if got := template.Path(test.metric, test.tags); got != test.want {
+			t.Errorf("%q.Path(%q, %v) = %q, want %q", test.template, test.metric, test.tags, got, test.want)
+		}
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, text := range []string{"t128.{}", "t128.{router", "t128.router}", "{{metric}}"} {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded", text)
+		}
+	}
+}
@@ -13,6 +13,25 @@ import (
 type Client struct {
 	httpClient influx.Client
 	database   string
+	tags       *TagMapper
+	naming     *Naming
+	routes     []Route
+
+	address  string
+	username string
+	password string
+	options  WriteOptions
+	writer   pointWriter
+	buffer   *buffer
+
+	// watermarks replace queries for the last recorded times when writing over UDP
+	watermarks *watermarks
+}
+
+// defaultWriteOptions writes every point as soon as it's sent
+var defaultWriteOptions = WriteOptions{
+	MetricPrecision: "ms",
+	SeriesPrecision: "ns",
 }
 
 // Record represents an influx data point
@@ -35,9 +54,19 @@ func CreateClient(address string, database string, username string, password str
 		return nil, fmt.Errorf("failure to create Influx client. %v", err)
 	}
 
+	writer, err := newHTTPWriter(address, username, password, defaultWriteOptions)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &Client{
 		httpClient: httpClient,
 		database:   database,
+		address:    address,
+		username:   username,
+		password:   password,
+		options:    defaultWriteOptions,
+		writer:     writer,
 	}
 
 	_, _, err = client.httpClient.Ping(5 * time.Second)
@@ -48,63 +77,94 @@ func CreateClient(address string, database string, username string, password str
 	return client, nil
 }
 
-// Send flushes a series of AnalyticPoints to InfluxDB
-func (client Client) Send(metric string, tags map[string]string, points []t128.AnalyticPoint) error {
-	config := influx.BatchPointsConfig{
-		Database:  client.database,
-		Precision: "ms",
-	}
+// Send flushes a series of AnalyticPoints to InfluxDB. The value of each point is written
+// to the valueField and the given fields are written alongside it.
+func (client Client) Send(metric string, valueField string, tags map[string]string, fields map[string]interface{}, points []t128.AnalyticPoint) error {
+	dest := client.destination(metric, "", tags["router"])
 
-	bp, err := influx.NewBatchPoints(config)
+	measurement, tags, err := client.naming.metric(metric, tags)
 	if err != nil {
 		return err
 	}
 
+	tags = client.tags.Apply(tags)
+	pts := make([]*influx.Point, 0, len(points))
 	for _, point := range points {
 		timestamp, err := time.Parse(time.RFC3339, point.Time)
 		if err != nil {
 			return err
 		}
 
-		fields := map[string]interface{}{"value": point.Value}
+		pointFields := map[string]interface{}{valueField: point.Value}
+		for k, v := range fields {
+			pointFields[k] = v
+		}
 
-		pt, err := influx.NewPoint(metric, tags, fields, timestamp)
+		pt, err := influx.NewPoint(measurement, tags, pointFields, timestamp)
 		if err != nil {
 			return err
 		}
 
-		bp.AddPoint(pt)
+		pts = append(pts, pt)
 	}
 
-	return client.httpClient.Write(bp)
+	return client.write(batchKey{dest, client.options.MetricPrecision}, pts)
 }
 
-// Insert adds multiple records to a series in a batch
+// Insert adds multiple records to a series in a batch. Records routed to different
+// databases or retention policies are written in separate batches.
 func (client Client) Insert(series string, records []Record) error {
-	config := influx.BatchPointsConfig{
-		Database:  client.database,
-		Precision: "ns",
-	}
-
-	bp, err := influx.NewBatchPoints(config)
-	if err != nil {
-		return err
-	}
+	batches := make(map[batchKey][]*influx.Point)
+	var order []batchKey
 
+	measurement := client.naming.series(series)
 	for _, r := range records {
-		pt, err := influx.NewPoint(series, r.Tags, r.Fields, r.Time)
+		key := batchKey{client.destination("", series, r.Tags["router"]), client.options.SeriesPrecision}
+		if _, ok := batches[key]; !ok {
+			order = append(order, key)
+		}
+
+		pt, err := influx.NewPoint(measurement, client.tags.Apply(r.Tags), r.Fields, r.Time)
 		if err != nil {
 			return err
 		}
 
-		bp.AddPoint(pt)
+		batches[key] = append(batches[key], pt)
 	}
 
-	return client.httpClient.Write(bp)
+	for _, key := range order {
+		if err := client.write(key, batches[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // LastRecordedTime retrieves the last time a record was added for a metric
 func (client Client) LastRecordedTime(metric string, tags map[string]string) (*time.Time, error) {
+	dest := client.destination(metric, "", tags["router"])
+
+	measurement, tags, err := client.naming.metric(metric, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.lastRecordedTime(dest, measurement, tags)
+}
+
+// LastInsertedTime retrieves the last time a record was inserted into a series
+func (client Client) LastInsertedTime(series string, tags map[string]string) (*time.Time, error) {
+	dest := client.destination("", series, tags["router"])
+	return client.lastRecordedTime(dest, client.naming.series(series), tags)
+}
+
+func (client Client) lastRecordedTime(dest destination, measurement string, tags map[string]string) (*time.Time, error) {
+	tags = client.tags.Apply(tags)
+	if client.watermarks != nil {
+		return client.watermarks.last(measurement, tags)
+	}
+
 	whereClauses := make([]string, 0, len(tags))
 
 	for k, v := range tags {
@@ -117,10 +177,10 @@ func (client Client) LastRecordedTime(metric string, tags map[string]string) (*t
 		whereClause = "where " + strings.Join(whereClauses, " and ")
 	}
 
-	query := fmt.Sprintf("SELECT * from \"%v\" %v order by time desc limit 1", metric, whereClause)
+	query := fmt.Sprintf("SELECT * from %v %v order by time desc limit 1", dest.from(measurement), whereClause)
 
 	res, err := client.httpClient.Query(influx.Query{
-		Database: client.database,
+		Database: dest.database,
 		Command:  query,
 	})
 
@@ -129,7 +189,7 @@ func (client Client) LastRecordedTime(metric string, tags map[string]string) (*t
 	}
 
 	if len(res.Results) == 0 || len(res.Results[0].Series) == 0 || len(res.Results[0].Series[0].Values) == 0 {
-		return nil, fmt.Errorf("previous recorded time does not exist for %v %v", metric, whereClauses)
+		return nil, fmt.Errorf("previous recorded time does not exist for %v %v", measurement, whereClauses)
 	}
 
 	row := res.Results[0].Series[0].Values[0]
@@ -0,0 +1,72 @@
+package influx
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+// measurementFuncs are the functions available to measurement templates
+var measurementFuncs = template.FuncMap{
+	"replace": func(old string, new string, s string) string { return strings.Replace(s, old, new, -1) },
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+}
+
+// ParseMeasurementTemplate parses a template used to name the measurement of a metric,
+// e.g. `t128_{{.MetricID | replace "/" "_"}}`
+func ParseMeasurementTemplate(text string) (*template.Template, error) {
+	return template.New("measurement").Funcs(measurementFuncs).Parse(text)
+}
+
+// Naming determines the names of the measurements written to Influx
+type Naming struct {
+	// Prefix is added to every measurement, including those that aren't metrics
+	Prefix string
+
+	// Template names the measurement of each metric
+	Template *template.Template
+
+	// WideMeasurement, when set, writes every metric to a single measurement with
+	// the metric ID as a tag rather than a measurement per metric
+	WideMeasurement string
+}
+
+// metric determines the measurement and tags a metric is written with
+func (n *Naming) metric(metricID string, tags map[string]string) (string, map[string]string, error) {
+	if n == nil {
+		return metricID, tags, nil
+	}
+
+	if n.WideMeasurement != "" {
+		wideTags := make(map[string]string, len(tags)+1)
+		for k, v := range tags {
+			wideTags[k] = v
+		}
+		wideTags["metric"] = metricID
+
+		return n.Prefix + n.WideMeasurement, wideTags, nil
+	}
+
+	if n.Template == nil {
+		return n.Prefix + metricID, tags, nil
+	}
+
+	var name bytes.Buffer
+	err := n.Template.Execute(&name, struct{ MetricID string }{metricID})
+	return n.Prefix + name.String(), tags, err
+}
+
+// series determines the measurement a series of records is written to
+func (n *Naming) series(series string) string {
+	if n == nil {
+		return series
+	}
+
+	return n.Prefix + series
+}
+
+// NameMeasurements sets how the measurements written to Influx are named
+func (client *Client) NameMeasurements(naming *Naming) {
+	client.naming = naming
+}
@@ -0,0 +1,81 @@
+package influx
+
+import (
+	"fmt"
+	"path"
+)
+
+// Route directs the points of matching metrics, series and routers to a database and
+// retention policy. A route without metrics or series matches everything, otherwise
+// metrics are matched against Metrics and other series against Series. A route without
+// routers matches every router. All patterns are shell style, e.g. "bandwidth*".
+type Route struct {
+	Metrics         []string
+	Series          []string
+	Routers         []string
+	Database        string
+	RetentionPolicy string
+}
+
+// destination represents where a batch of points is written
+type destination struct {
+	database        string
+	retentionPolicy string
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Route) matches(metric string, series string, router string) bool {
+	if len(r.Metrics) != 0 || len(r.Series) != 0 {
+		if metric != "" && !matchesAny(r.Metrics, metric) {
+			return false
+		}
+		if series != "" && !matchesAny(r.Series, series) {
+			return false
+		}
+	}
+
+	return len(r.Routers) == 0 || matchesAny(r.Routers, router)
+}
+
+// destination determines where the points of a metric or series are written. The first
+// matching route wins and anything unmatched goes to the default retention policy of
+// the client's database.
+func (client Client) destination(metric string, series string, router string) destination {
+	for _, route := range client.routes {
+		if !route.matches(metric, series, router) {
+			continue
+		}
+
+		dest := destination{database: client.database, retentionPolicy: route.RetentionPolicy}
+		if route.Database != "" {
+			dest.database = route.Database
+		}
+
+		return dest
+	}
+
+	return destination{database: client.database}
+}
+
+// from builds the FROM clause of a query against a measurement within the destination
+func (dest destination) from(measurement string) string {
+	if dest.retentionPolicy == "" {
+		return fmt.Sprintf("\"%v\"", measurement)
+	}
+
+	return fmt.Sprintf("\"%v\".\"%v\"", dest.retentionPolicy, measurement)
+}
+
+// Route sets the rules used to direct points to databases and retention policies
+func (client *Client) Route(routes []Route) {
+	client.routes = routes
+}
@@ -0,0 +1,181 @@
+package influx
+
+import (
+	"fmt"
+
+	"github.com/128technology/influx-importer/logger"
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// RetentionPolicy represents an Influx retention policy to be created by Setup.
+// Durations use Influx's duration syntax, e.g. "7d" or "INF".
+type RetentionPolicy struct {
+	Name          string
+	Duration      string
+	ShardDuration string
+	Replication   int
+	Default       bool
+}
+
+// Rollup represents a continuous query downsampling every metric into a measurement
+// named after the metric with the suffix appended, e.g. "bandwidth_1h".
+type Rollup struct {
+	Interval        string
+	Suffix          string
+	Function        string
+	RetentionPolicy string
+}
+
+func (client Client) exec(database string, command string) (*influx.Response, error) {
+	res, err := client.httpClient.Query(influx.Query{
+		Database: database,
+		Command:  command,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, res.Error()
+}
+
+// names returns the first column of every row a query returned, grouped by series name
+func (client Client) names(database string, command string) (map[string]map[string]bool, error) {
+	res, err := client.exec(database, command)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]map[string]bool)
+	for _, result := range res.Results {
+		for _, series := range result.Series {
+			if names[series.Name] == nil {
+				names[series.Name] = make(map[string]bool)
+			}
+
+			for _, row := range series.Values {
+				if name, ok := row[0].(string); ok {
+					names[series.Name][name] = true
+				}
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// databases returns every database the client writes to
+func (client Client) databases() []string {
+	databases := []string{client.database}
+	seen := map[string]bool{client.database: true}
+
+	for _, route := range client.routes {
+		if route.Database != "" && !seen[route.Database] {
+			seen[route.Database] = true
+			databases = append(databases, route.Database)
+		}
+	}
+
+	return databases
+}
+
+// Setup creates any missing databases the client writes to along with the given
+// retention policies within each of them. A continuous query is then installed for
+// every rollup of every metric. Existing retention policies and continuous queries
+// are left untouched.
+func (client Client) Setup(policies []RetentionPolicy, rollups []Rollup, metrics []string) error {
+	existing, err := client.names("", "SHOW DATABASES")
+	if err != nil {
+		return fmt.Errorf("unable to list databases: %v", err)
+	}
+
+	for _, database := range client.databases() {
+		if !existing["databases"][database] {
+			if _, err := client.exec("", fmt.Sprintf("CREATE DATABASE \"%v\"", database)); err != nil {
+				return fmt.Errorf("unable to create database %v: %v", database, err)
+			}
+			logger.Log.Info("Created database %v\n", database)
+		}
+
+		if err := client.createRetentionPolicies(database, policies); err != nil {
+			return err
+		}
+	}
+
+	return client.createContinuousQueries(rollups, metrics)
+}
+
+func (client Client) createRetentionPolicies(database string, policies []RetentionPolicy) error {
+	existing, err := client.names(database, fmt.Sprintf("SHOW RETENTION POLICIES ON \"%v\"", database))
+	if err != nil {
+		return fmt.Errorf("unable to list retention policies of %v: %v", database, err)
+	}
+
+	for _, policy := range policies {
+		// Retention policies are listed in an unnamed series
+		if existing[""][policy.Name] {
+			continue
+		}
+
+		command := fmt.Sprintf("CREATE RETENTION POLICY \"%v\" ON \"%v\" DURATION %v REPLICATION %v",
+			policy.Name, database, policy.Duration, policy.Replication)
+		if policy.ShardDuration != "" {
+			command += " SHARD DURATION " + policy.ShardDuration
+		}
+		if policy.Default {
+			command += " DEFAULT"
+		}
+
+		if _, err := client.exec(database, command); err != nil {
+			return fmt.Errorf("unable to create retention policy %v on %v: %v", policy.Name, database, err)
+		}
+		logger.Log.Info("Created retention policy %v on %v\n", policy.Name, database)
+	}
+
+	return nil
+}
+
+func (client Client) createContinuousQueries(rollups []Rollup, metrics []string) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	existing, err := client.names("", "SHOW CONTINUOUS QUERIES")
+	if err != nil {
+		return fmt.Errorf("unable to list continuous queries: %v", err)
+	}
+
+	for _, metric := range metrics {
+		// Routes limited to particular routers can't be resolved for a whole metric so
+		// the rollups read from wherever the metric goes by default
+		dest := client.destination(metric, "", "")
+
+		measurement, _, err := client.naming.metric(metric, map[string]string{})
+		if err != nil {
+			return err
+		}
+
+		for _, rollup := range rollups {
+			name := measurement + rollup.Suffix
+			if existing[dest.database][name] {
+				continue
+			}
+
+			into := destination{database: dest.database, retentionPolicy: rollup.RetentionPolicy}
+			command := fmt.Sprintf("CREATE CONTINUOUS QUERY \"%v\" ON \"%v\" BEGIN SELECT %v(*) INTO %v FROM %v GROUP BY time(%v), * END",
+				name, dest.database, rollup.Function, into.from(name), dest.from(measurement), rollup.Interval)
+
+			if _, err := client.exec(dest.database, command); err != nil {
+				return fmt.Errorf("unable to create continuous query %v on %v: %v", name, dest.database, err)
+			}
+			logger.Log.Info("Created continuous query %v on %v\n", name, dest.database)
+
+			// A wide measurement is shared by every metric so only needs one query
+			if existing[dest.database] == nil {
+				existing[dest.database] = make(map[string]bool)
+			}
+			existing[dest.database][name] = true
+		}
+	}
+
+	return nil
+}
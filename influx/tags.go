@@ -0,0 +1,62 @@
+package influx
+
+import (
+	"regexp"
+)
+
+// TagRewrite replaces the parts of a tag's value matching a pattern
+type TagRewrite struct {
+	Key         string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// TagMapper rewrites the tags of every point written to, and queried from, Influx
+type TagMapper struct {
+	Rename   map[string]string
+	Drop     []string
+	Static   map[string]string
+	Rewrites []TagRewrite
+}
+
+// Apply returns a copy of the tags with the mapping applied. Values are rewritten and
+// keys dropped by their original name before keys are renamed. Static tags are added last.
+func (m *TagMapper) Apply(tags map[string]string) map[string]string {
+	if m == nil {
+		return tags
+	}
+
+	values := make(map[string]string, len(tags))
+	for k, v := range tags {
+		values[k] = v
+	}
+
+	for _, rewrite := range m.Rewrites {
+		if v, ok := values[rewrite.Key]; ok {
+			values[rewrite.Key] = rewrite.Pattern.ReplaceAllString(v, rewrite.Replacement)
+		}
+	}
+
+	for _, k := range m.Drop {
+		delete(values, k)
+	}
+
+	mapped := make(map[string]string, len(values)+len(m.Static))
+	for k, v := range values {
+		if renamed, ok := m.Rename[k]; ok {
+			k = renamed
+		}
+		mapped[k] = v
+	}
+
+	for k, v := range m.Static {
+		mapped[k] = v
+	}
+
+	return mapped
+}
+
+// MapTags sets the mapping applied to the tags of every point written to Influx
+func (client *Client) MapTags(mapper *TagMapper) {
+	client.tags = mapper
+}
@@ -0,0 +1,59 @@
+package influx
+
+import (
+	"fmt"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// udpWriter writes line protocol to an Influx UDP listener. The listener decides the
+// database and retention policy, so every point is written to the same place. The
+// watermarks only advance once a write succeeds.
+type udpWriter struct {
+	client     influx.Client
+	watermarks *watermarks
+}
+
+func (w *udpWriter) write(key batchKey, points []*influx.Point) error {
+	bp, err := influx.NewBatchPoints(influx.BatchPointsConfig{Precision: key.precision})
+	if err != nil {
+		return err
+	}
+
+	bp.AddPoints(points)
+	if err := w.client.Write(bp); err != nil {
+		return err
+	}
+
+	w.watermarks.record(points)
+	return nil
+}
+
+// CreateUDPClient creates a client that fires points at an Influx UDP listener without
+// waiting for them to be acknowledged. UDP has no query path, so the last recorded times
+// are kept in a local watermark file which is saved when the client is closed. Watermarks
+// older than maxAge, the furthest back anything is queried, are dropped when saving.
+func CreateUDPClient(address string, payloadSize int, database string, watermarkFile string, maxAge time.Duration) (*Client, error) {
+	udpClient, err := influx.NewUDPClient(influx.UDPConfig{
+		Addr:        address,
+		PayloadSize: payloadSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failure to create Influx UDP client. %v", err)
+	}
+
+	marks, err := loadWatermarks(watermarkFile, maxAge)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load watermarks: %v", err)
+	}
+
+	return &Client{
+		httpClient: udpClient,
+		database:   database,
+		address:    address,
+		options:    defaultWriteOptions,
+		writer:     &udpWriter{client: udpClient, watermarks: marks},
+		watermarks: marks,
+	}, nil
+}
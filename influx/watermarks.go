@@ -0,0 +1,173 @@
+package influx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// watermarks remember the time of the latest point written to each series so that the
+// last recorded time can be found without querying Influx. A nil watermarks is valid
+// and records nothing.
+type watermarks struct {
+	filename string
+
+	// maxAge is how old a watermark can be before it's dropped on save. Nothing queries
+	// further back than this, so an older watermark is no better than none.
+	maxAge time.Duration
+
+	// series maps each measurement to the watermarks of its series, keyed by their tags
+	mutex  sync.Mutex
+	series map[string]map[string]*watermark
+}
+
+type watermark struct {
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags"`
+	Time        time.Time         `json:"time"`
+}
+
+func loadWatermarks(filename string, maxAge time.Duration) (*watermarks, error) {
+	w := &watermarks{
+		filename: filename,
+		maxAge:   maxAge,
+		series:   make(map[string]map[string]*watermark),
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return w, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var marks []*watermark
+	if err := json.Unmarshal(data, &marks); err != nil {
+		return nil, err
+	}
+
+	for _, mark := range marks {
+		w.measurement(mark.Measurement)[tagsKey(mark.Tags)] = mark
+	}
+
+	return w, nil
+}
+
+func tagsKey(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// measurement returns the watermarks of a measurement's series. It must be called with
+// the mutex held.
+func (w *watermarks) measurement(name string) map[string]*watermark {
+	series, ok := w.series[name]
+	if !ok {
+		series = make(map[string]*watermark)
+		w.series[name] = series
+	}
+
+	return series
+}
+
+func (w *watermarks) record(points []*influx.Point) {
+	if w == nil {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, pt := range points {
+		series := w.measurement(pt.Name())
+		key := tagsKey(pt.Tags())
+		if mark, ok := series[key]; ok {
+			if pt.Time().After(mark.Time) {
+				mark.Time = pt.Time()
+			}
+			continue
+		}
+
+		series[key] = &watermark{Measurement: pt.Name(), Tags: pt.Tags(), Time: pt.Time()}
+	}
+}
+
+// last finds the latest point written to the measurement within any series carrying
+// the given tags, matching a "where" query against Influx
+func (w *watermarks) last(measurement string, tags map[string]string) (*time.Time, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var latest *time.Time
+	for _, mark := range w.series[measurement] {
+		if !hasTags(mark.Tags, tags) {
+			continue
+		}
+
+		if latest == nil || mark.Time.After(*latest) {
+			t := mark.Time
+			latest = &t
+		}
+	}
+
+	if latest == nil {
+		return nil, fmt.Errorf("previous recorded time does not exist for %v %v", measurement, tags)
+	}
+
+	return latest, nil
+}
+
+func hasTags(tags map[string]string, want map[string]string) bool {
+	for k, v := range want {
+		if tags[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// save writes the watermarks to the file, first dropping those older than the max age
+func (w *watermarks) save() error {
+	if w == nil {
+		return nil
+	}
+
+	oldest := time.Now().Add(-w.maxAge)
+
+	w.mutex.Lock()
+	var marks []*watermark
+	for name, series := range w.series {
+		for key, mark := range series {
+			if w.maxAge > 0 && mark.Time.Before(oldest) {
+				delete(series, key)
+				continue
+			}
+			marks = append(marks, mark)
+		}
+
+		if len(series) == 0 {
+			delete(w.series, name)
+		}
+	}
+	data, err := json.Marshal(marks)
+	w.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(w.filename, data, 0644)
+}
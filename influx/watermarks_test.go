@@ -0,0 +1,74 @@
+package influx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+func TestWatermarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watermarks")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "watermarks")
+
+	w, err := loadWatermarks(filename, time.Hour)
+	if err != nil {
+		t.Fatalf("loadWatermarks() = %v", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	point := func(measurement string, tags map[string]string, age time.Duration) *influx.Point {
+		pt, err := influx.NewPoint(measurement, tags, map[string]interface{}{"value": 1}, now.Add(-age))
+		if err != nil {
+			t.Fatalf("NewPoint() = %v", err)
+		}
+		return pt
+	}
+
+	w.record([]*influx.Point{
+		point("cpu", map[string]string{"router": "east", "node": "a"}, 3*time.Minute),
+		point("cpu", map[string]string{"router": "east", "node": "b"}, time.Minute),
+		point("cpu", map[string]string{"router": "east", "node": "a"}, 2*time.Minute),
+		point("cpu", map[string]string{"router": "west"}, 2*time.Hour),
+		point("memory", map[string]string{"router": "east"}, 0),
+	})
+
+	tests := []struct {
+		measurement string
+		tags        map[string]string
+		want        time.Time
+	}{
+		{"cpu", map[string]string{"router": "east"}, now.Add(-time.Minute)},
+		{"cpu", map[string]string{"router": "east", "node": "a"}, now.Add(-2 * time.Minute)},
+		{"cpu", map[string]string{"router": "west"}, now.Add(-2 * time.Hour)},
+		{"memory", nil, now},
+	}
+	for _, test := range tests {
+		if got, err := w.last(test.measurement, test.tags); err != nil || !got.Equal(test.want) {
+			t.Errorf("last(%v, %v) = %v, %v, want %v", test.measurement, test.tags, got, err, test.want)
+		}
+	}
+
+	if err := w.save(); err != nil {
+		t.Fatalf("save() = %v", err)
+	}
+
+	// Watermarks older than the max age aren't kept
+	loaded, err := loadWatermarks(filename, time.Hour)
+	if err != nil {
+		t.Fatalf("loadWatermarks() = %v", err)
+	}
+	if got, err := loaded.last("cpu", map[string]string{"router": "east"}); err != nil || !got.Equal(now.Add(-time.Minute)) {
+		t.Errorf("last() after loading = %v, %v, want %v", got, err, now.Add(-time.Minute))
+	}
+	if got, err := loaded.last("cpu", map[string]string{"router": "west"}); err == nil {
+		t.Errorf("last() of an expired watermark = %v, want an error", got)
+	}
+}
@@ -0,0 +1,389 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/128technology/influx-importer/logger"
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// WriteOptions configures how points are written to Influx
+type WriteOptions struct {
+	// BatchSize is the number of points buffered before they're written. Points are
+	// written as soon as they're sent when BatchSize is 0.
+	BatchSize int
+
+	// FlushInterval bounds how long a point can sit in the buffer
+	FlushInterval time.Duration
+
+	// BufferSize bounds how many points are held while Influx can't be written to.
+	// The oldest points are dropped beyond it. There is no bound when it's 0.
+	BufferSize int
+
+	// MetricPrecision and SeriesPrecision are the precisions metric points and series
+	// records are written with, e.g. "ms" or "ns"
+	MetricPrecision string
+	SeriesPrecision string
+
+	// Gzip compresses the body of every write request
+	Gzip bool
+
+	// Consistency is the write consistency of clustered Influx instances, e.g. "any"
+	Consistency string
+
+	// Timeout bounds each write request. There is no timeout when it's 0.
+	Timeout time.Duration
+}
+
+// batchKey identifies points that can be written within the same request
+type batchKey struct {
+	dest      destination
+	precision string
+}
+
+// pointWriter writes a batch of points to Influx
+type pointWriter interface {
+	write(key batchKey, points []*influx.Point) error
+}
+
+// httpWriter writes line protocol to Influx's /write endpoint
+type httpWriter struct {
+	url         url.URL
+	username    string
+	password    string
+	gzip        bool
+	consistency string
+	httpClient  *http.Client
+}
+
+func newHTTPWriter(address string, username string, password string, options WriteOptions) (*httpWriter, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse Influx address %v: %v", address, err)
+	}
+	u.Path = path.Join(u.Path, "write")
+
+	return &httpWriter{
+		url:         *u,
+		username:    username,
+		password:    password,
+		gzip:        options.Gzip,
+		consistency: options.Consistency,
+		httpClient:  &http.Client{Timeout: options.Timeout},
+	}, nil
+}
+
+func (w *httpWriter) write(key batchKey, points []*influx.Point) error {
+	var body bytes.Buffer
+	var out io.Writer = &body
+
+	var compressor *gzip.Writer
+	if w.gzip {
+		compressor = gzip.NewWriter(&body)
+		out = compressor
+	}
+
+	for _, pt := range points {
+		if _, err := io.WriteString(out, pt.PrecisionString(key.precision)+"\n"); err != nil {
+			return err
+		}
+	}
+
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return err
+		}
+	}
+
+	params := url.Values{}
+	params.Set("db", key.dest.database)
+	params.Set("precision", key.precision)
+	if key.dest.retentionPolicy != "" {
+		params.Set("rp", key.dest.retentionPolicy)
+	}
+	if w.consistency != "" {
+		params.Set("consistency", w.consistency)
+	}
+
+	u := w.url
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest("POST", u.String(), &body)
+	if err != nil {
+		return err
+	}
+	if w.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("influx write failed with status %v: %v", resp.StatusCode, string(msg))
+	}
+
+	return nil
+}
+
+// buffer aggregates points across sends until there are enough of them, or they've
+// waited long enough, to be worth a write request. Batches that fail to be written
+// stay buffered, up to the buffer's limit, and are retried with the next flush.
+type buffer struct {
+	size   int
+	limit  int
+	writer pointWriter
+
+	mutex   sync.Mutex
+	batches map[batchKey][]*influx.Point
+	order   []batchKey
+	count   int
+
+	// added is the number of points added since the last flush. A flush is triggered by
+	// adding size points rather than by holding them so that a down Influx isn't
+	// retried on every add.
+	added int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBuffer(writer pointWriter, size int, limit int, interval time.Duration) *buffer {
+	b := &buffer{
+		size:    size,
+		limit:   limit,
+		writer:  writer,
+		batches: make(map[batchKey][]*influx.Point),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go b.run(interval)
+	return b
+}
+
+func (b *buffer) run(interval time.Duration) {
+	defer close(b.done)
+
+	if interval <= 0 {
+		<-b.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.flush(); err != nil {
+				logger.Log.Warn("Unable to write buffered points to Influx: %v. Retrying with the next flush.\n", err.Error())
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// add buffers points and writes everything buffered once the buffer is full. Points
+// that can't be written stay buffered so only close reports them as lost.
+func (b *buffer) add(key batchKey, points []*influx.Point) {
+	b.mutex.Lock()
+	if _, ok := b.batches[key]; !ok {
+		b.order = append(b.order, key)
+	}
+	b.batches[key] = append(b.batches[key], points...)
+	b.count += len(points)
+	b.added += len(points)
+	b.trim()
+	full := b.added >= b.size
+	b.mutex.Unlock()
+
+	if !full {
+		return
+	}
+
+	if err := b.flush(); err != nil {
+		logger.Log.Warn("Unable to write buffered points to Influx: %v. Retrying with the next flush.\n", err.Error())
+	}
+}
+
+// flush writes everything buffered. Every batch is attempted, the ones that fail are
+// buffered again ahead of anything added since and the first error is returned.
+func (b *buffer) flush() error {
+	b.mutex.Lock()
+	batches, order := b.batches, b.order
+	b.batches = make(map[batchKey][]*influx.Point)
+	b.order = nil
+	b.count = 0
+	b.added = 0
+	b.mutex.Unlock()
+
+	var firstErr error
+	var failed []batchKey
+	for _, key := range order {
+		if err := b.writer.write(key, batches[key]); err != nil {
+			failed = append(failed, key)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	requeued := make(map[batchKey][]*influx.Point)
+	for _, key := range failed {
+		requeued[key] = batches[key]
+		b.count += len(batches[key])
+	}
+
+	// Points added while writing follow the ones that failed
+	for _, key := range b.order {
+		if _, ok := requeued[key]; !ok {
+			failed = append(failed, key)
+		}
+		requeued[key] = append(requeued[key], b.batches[key]...)
+	}
+
+	b.batches = requeued
+	b.order = failed
+	b.trim()
+
+	return firstErr
+}
+
+// trim drops the oldest points once more than the limit are held. It must be called
+// with the mutex held.
+func (b *buffer) trim() {
+	dropped := b.count - b.limit
+	if b.limit == 0 || dropped <= 0 {
+		return
+	}
+
+	logger.Log.Warn("Influx buffer is full. Dropping the oldest %v points.\n", dropped)
+	for dropped > 0 {
+		key := b.order[0]
+		batch := b.batches[key]
+		if len(batch) > dropped {
+			b.batches[key] = batch[dropped:]
+			b.count -= dropped
+			return
+		}
+
+		delete(b.batches, key)
+		b.order = b.order[1:]
+		b.count -= len(batch)
+		dropped -= len(batch)
+	}
+}
+
+// close stops the periodic flush and writes everything buffered. An error means some
+// points were never written.
+func (b *buffer) close() error {
+	close(b.stop)
+	<-b.done
+
+	if err := b.flush(); err != nil {
+		return fmt.Errorf("%v buffered points could not be written: %v", b.count, err)
+	}
+
+	return nil
+}
+
+// write sends points to Influx, through the buffer when batching is enabled
+func (client Client) write(key batchKey, points []*influx.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	if client.buffer != nil {
+		client.buffer.add(key, points)
+		return nil
+	}
+
+	return client.writer.write(key, points)
+}
+
+// ConfigureWrites sets how points are written to Influx. Close must be called once
+// writing is finished when batching is enabled.
+func (client *Client) ConfigureWrites(options WriteOptions) error {
+	// Only the batching and precision options apply to UDP
+	writer := client.writer
+	if _, udp := writer.(*udpWriter); !udp {
+		var err error
+		writer, err = newHTTPWriter(client.address, client.username, client.password, options)
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.MetricPrecision == "" {
+		options.MetricPrecision = defaultWriteOptions.MetricPrecision
+	}
+	if options.SeriesPrecision == "" {
+		options.SeriesPrecision = defaultWriteOptions.SeriesPrecision
+	}
+
+	if client.buffer != nil {
+		if err := client.buffer.close(); err != nil {
+			return err
+		}
+		client.buffer = nil
+	}
+
+	client.options = options
+	client.writer = writer
+	if options.BatchSize > 0 {
+		client.buffer = newBuffer(writer, options.BatchSize, options.BufferSize, options.FlushInterval)
+	}
+
+	return nil
+}
+
+// Flush writes any buffered points
+func (client Client) Flush() error {
+	if client.buffer == nil {
+		return nil
+	}
+
+	return client.buffer.flush()
+}
+
+// Close writes any buffered points, stops flushing the buffer periodically and saves
+// the watermarks of a UDP client. The watermarks are saved even if writing fails so
+// that the points that were written aren't fetched again.
+func (client *Client) Close() error {
+	var err error
+	if client.buffer != nil {
+		err = client.buffer.close()
+		client.buffer = nil
+	}
+
+	if saveErr := client.watermarks.save(); saveErr != nil && err == nil {
+		err = fmt.Errorf("unable to save watermarks: %v", saveErr)
+	}
+
+	return err
+}
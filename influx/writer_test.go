@@ -0,0 +1,143 @@
+package influx
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	influx "github.com/influxdata/influxdb/client/v2"
+)
+
+// fakeWriter records the batches it's asked to write, failing those of the keys in fail
+type fakeWriter struct {
+	fail    map[batchKey]bool
+	written []string
+
+	// onWrite is called before each batch is written
+	onWrite func()
+}
+
+func (w *fakeWriter) write(key batchKey, points []*influx.Point) error {
+	if w.onWrite != nil {
+		w.onWrite()
+	}
+	if w.fail[key] {
+		return fmt.Errorf("unable to write %v", key.dest.database)
+	}
+
+	w.written = append(w.written, key.dest.database+fmt.Sprint(values(points)))
+	return nil
+}
+
+func key(database string) batchKey {
+	return batchKey{dest: destination{database: database}, precision: "ms"}
+}
+
+func makePoints(values ...float64) []*influx.Point {
+	pts := make([]*influx.Point, len(values))
+	for i, v := range values {
+		pt, err := influx.NewPoint("m", nil, map[string]interface{}{"v": v}, time.Unix(int64(v), 0))
+		if err != nil {
+			panic(err)
+		}
+		pts[i] = pt
+	}
+	return pts
+}
+
+func values(points []*influx.Point) []float64 {
+	var vs []float64
+	for _, pt := range points {
+		fields, _ := pt.Fields()
+		vs = append(vs, fields["v"].(float64))
+	}
+	return vs
+}
+
+// buffered lists what the buffer holds in the order it would be written
+func buffered(b *buffer) []string {
+	var batches []string
+	for _, k := range b.order {
+		batches = append(batches, k.dest.database+fmt.Sprint(values(b.batches[k])))
+	}
+	return batches
+}
+
+func TestFlushRequeuesFailures(t *testing.T) {
+	w := &fakeWriter{fail: map[batchKey]bool{key("a"): true}}
+	b := &buffer{size: 100, writer: w, batches: make(map[batchKey][]*influx.Point)}
+
+	b.add(key("a"), makePoints(0, 1))
+	b.add(key("b"), makePoints(2))
+
+	// Points added while the flush is writing are queued behind those that failed
+	w.onWrite = func() {
+		w.onWrite = nil
+		b.add(key("c"), makePoints(3))
+		b.add(key("a"), makePoints(4))
+	}
+
+	if err := b.flush(); err == nil {
+		t.Fatalf("flush() succeeded despite a failing batch")
+	}
+	if want := []string{"b[2]"}; !reflect.DeepEqual(w.written, want) {
+		t.Errorf("written = %v, want %v", w.written, want)
+	}
+	if want := []string{"a[0 1 4]", "c[3]"}; !reflect.DeepEqual(buffered(b), want) {
+		t.Errorf("buffered = %v, want %v", buffered(b), want)
+	}
+	if b.count != 4 {
+		t.Errorf("count = %v, want 4", b.count)
+	}
+
+	w.fail = nil
+	if err := b.flush(); err != nil {
+		t.Fatalf("flush() = %v", err)
+	}
+	if want := []string{"b[2]", "a[0 1 4]", "c[3]"}; !reflect.DeepEqual(w.written, want) {
+		t.Errorf("written = %v, want %v", w.written, want)
+	}
+	if len(b.order) != 0 || b.count != 0 {
+		t.Errorf("buffer still holds %v after a successful flush", buffered(b))
+	}
+}
+
+func TestAddFlushesEverySizePoints(t *testing.T) {
+	w := &fakeWriter{fail: map[batchKey]bool{key("a"): true}}
+	b := &buffer{size: 2, writer: w, batches: make(map[batchKey][]*influx.Point)}
+
+	// A failed flush isn't retried until another size points have been added
+	attempts := 0
+	w.onWrite = func() { attempts++ }
+
+	b.add(key("a"), makePoints(0, 1))
+	b.add(key("a"), makePoints(2))
+	if attempts != 1 {
+		t.Errorf("%v write attempts after 3 points, want 1", attempts)
+	}
+
+	b.add(key("a"), makePoints(3))
+	if attempts != 2 {
+		t.Errorf("%v write attempts after 4 points, want 2", attempts)
+	}
+}
+
+func TestBufferLimit(t *testing.T) {
+	w := &fakeWriter{fail: map[batchKey]bool{key("a"): true, key("b"): true, key("c"): true}}
+	b := &buffer{size: 2, limit: 3, writer: w, batches: make(map[batchKey][]*influx.Point)}
+
+	b.add(key("a"), makePoints(0, 1))
+	b.add(key("b"), makePoints(2, 3))
+	if want := []string{"a[1]", "b[2 3]"}; !reflect.DeepEqual(buffered(b), want) {
+		t.Errorf("buffered = %v, want the newest %v", buffered(b), want)
+	}
+
+	b.add(key("c"), makePoints(4, 5))
+	if want := []string{"b[3]", "c[4 5]"}; !reflect.DeepEqual(buffered(b), want) {
+		t.Errorf("buffered = %v, want the newest %v", buffered(b), want)
+	}
+	if b.count != 3 {
+		t.Errorf("count = %v, want 3", b.count)
+	}
+}
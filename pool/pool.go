@@ -0,0 +1,81 @@
+package pool
+
+import (
+	"sync"
+
+	"github.com/abiosoft/semaphore"
+)
+
+// Pool runs jobs concurrently while bounding both the total number of jobs in
+// flight and the number of jobs in flight for any single key (e.g. a router).
+type Pool struct {
+	global    *semaphore.Semaphore
+	perKeyMax int
+
+	mutex  sync.Mutex
+	perKey map[string]*semaphore.Semaphore
+
+	wg sync.WaitGroup
+}
+
+// New creates a Pool that allows at most maxInFlight jobs to run at once with
+// no more than maxPerKey of them sharing the same key.
+func New(maxInFlight int, maxPerKey int) *Pool {
+	if maxPerKey > maxInFlight {
+		maxPerKey = maxInFlight
+	}
+
+	return &Pool{
+		global:    semaphore.New(maxInFlight),
+		perKeyMax: maxPerKey,
+		perKey:    make(map[string]*semaphore.Semaphore),
+	}
+}
+
+func (p *Pool) keySemaphore(key string) *semaphore.Semaphore {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sem, ok := p.perKey[key]
+	if !ok {
+		sem = semaphore.New(p.perKeyMax)
+		p.perKey[key] = sem
+	}
+
+	return sem
+}
+
+// Submit queues a job to run in the background. It blocks the caller until the
+// key has a free slot so that a single key cannot flood the pool with waiting jobs.
+func (p *Pool) Submit(key string, job func()) {
+	keySem := p.keySemaphore(key)
+	keySem.Acquire()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer keySem.Release()
+
+		p.global.Acquire()
+		defer p.global.Release()
+
+		job()
+	}()
+}
+
+// Do runs a job on the calling goroutine once both a key and a global slot are free.
+func (p *Pool) Do(key string, job func()) {
+	keySem := p.keySemaphore(key)
+	keySem.Acquire()
+	defer keySem.Release()
+
+	p.global.Acquire()
+	defer p.global.Release()
+
+	job()
+}
+
+// Wait blocks until all submitted jobs have completed.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
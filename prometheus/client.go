@@ -0,0 +1,214 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	t128 "github.com/128technology/influx-importer/client"
+	"github.com/128technology/influx-importer/logger"
+)
+
+// Client represents a connection to a Prometheus remote write receiver such as
+// Prometheus itself, Thanos Receive or Mimir
+type Client struct {
+	httpClient *http.Client
+	url        string
+	prefix     string
+	retries    int
+	backoff    time.Duration
+
+	username    string
+	password    string
+	bearerToken string
+	tenant      string
+}
+
+var (
+	invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]+`)
+	invalidLabelChars  = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+)
+
+// CreateClient creates a remote write client given the receiver's write URL, e.g.
+// http://localhost:9090/api/v1/write. Every metric name is given the prefix and
+// failed writes are retried up to retries times.
+func CreateClient(url string, prefix string, retries int, timeout time.Duration) *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: timeout},
+		url:        url,
+		prefix:     prefix,
+		retries:    retries,
+		backoff:    500 * time.Millisecond,
+	}
+}
+
+// Authenticate sets the credentials sent with every write. A bearer token takes
+// precedence over a username and password. The tenant is sent as the X-Scope-OrgID
+// header used by multi-tenant receivers.
+func (client *Client) Authenticate(username string, password string, bearerToken string, tenant string) {
+	client.username = username
+	client.password = password
+	client.bearerToken = bearerToken
+	client.tenant = tenant
+}
+
+// MetricName converts a 128T metric ID into a valid Prometheus metric name with the
+// given prefix, e.g. "aggregate-session/node/bandwidth" becomes
+// "t128_aggregate_session_node_bandwidth"
+func MetricName(prefix string, metricID string) string {
+	name := prefix + strings.Trim(invalidMetricChars.ReplaceAllString(metricID, "_"), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// labelName converts a tag into a valid Prometheus label name. Names beginning with
+// "__" are reserved by Prometheus so leading underscores are dropped.
+func labelName(tag string) string {
+	name := strings.TrimLeft(invalidLabelChars.ReplaceAllString(tag, "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// sortedLabels converts tags into labels sorted by name. When several tags convert to
+// the same name, e.g. "node-name" and "node_name", the tag already named that way wins,
+// and otherwise the tag that sorts first.
+func sortedLabels(tags map[string]string) []Label {
+	keys := make(map[string]string, len(tags))
+	for k, v := range tags {
+		// Prometheus treats an empty label as a missing one
+		if v == "" {
+			continue
+		}
+
+		name := labelName(k)
+		if existing, ok := keys[name]; ok && (existing == name || (k != name && existing < k)) {
+			continue
+		}
+		keys[name] = k
+	}
+
+	labels := make([]Label, 0, len(keys))
+	for name, k := range keys {
+		labels = append(labels, Label{Name: name, Value: tags[k]})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+	return labels
+}
+
+// timeSeries converts the points of a metric into a series sorted the way receivers expect
+func (client *Client) timeSeries(metricID string, tags map[string]string, points []t128.AnalyticPoint) (TimeSeries, error) {
+	labels := append([]Label{{Name: "__name__", Value: MetricName(client.prefix, metricID)}}, sortedLabels(tags)...)
+
+	samples := make([]Sample, 0, len(points))
+	for _, point := range points {
+		timestamp, err := time.Parse(time.RFC3339, point.Time)
+		if err != nil {
+			return TimeSeries{}, err
+		}
+
+		samples = append(samples, Sample{
+			Value:     point.Value,
+			Timestamp: timestamp.UnixNano() / int64(time.Millisecond),
+		})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+
+	return TimeSeries{Labels: labels, Samples: samples}, nil
+}
+
+// Send writes the points of a metric permutation to the receiver. The tags become the
+// series' labels.
+func (client *Client) Send(metricID string, tags map[string]string, points []t128.AnalyticPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	series, err := client.timeSeries(metricID, tags, points)
+	if err != nil {
+		return err
+	}
+
+	return client.Write([]TimeSeries{series})
+}
+
+// retryableError represents a failed write that may succeed if attempted again
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+// Write sends a remote write request holding the given series, retrying with an
+// exponential backoff when the receiver is unreachable, overloaded or failing
+func (client *Client) Write(series []TimeSeries) error {
+	body := snappyEncode(marshalWriteRequest(series))
+
+	backoff := client.backoff
+	for attempt := 0; ; attempt++ {
+		err := client.post(body)
+		if err == nil {
+			return nil
+		}
+
+		if _, ok := err.(retryableError); !ok || attempt >= client.retries {
+			return err
+		}
+
+		logger.Log.Warn("Prometheus remote write failed: %v. Retrying in %v\n", err.Error(), backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (client *Client) post(body []byte) error {
+	req, err := http.NewRequest("POST", client.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if client.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+client.bearerToken)
+	} else if client.username != "" {
+		req.SetBasicAuth(client.username, client.password)
+	}
+	if client.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", client.tenant)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return retryableError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	msg, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("remote write failed with status %v: %v", resp.Status, strings.TrimSpace(string(msg)))
+
+	// Anything else is a problem with the request itself which won't go away
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+		return retryableError{err}
+	}
+
+	return err
+}
@@ -0,0 +1,343 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	t128 "github.com/128technology/influx-importer/client"
+	"github.com/golang/snappy"
+)
+
+// protoField is a single decoded protobuf field
+type protoField struct {
+	number int
+	varint uint64
+	data   []byte
+}
+
+func parseProto(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid key")
+		}
+		b = b[n:]
+
+		field := protoField{number: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			if field.varint, n = binary.Uvarint(b); n <= 0 {
+				return nil, fmt.Errorf("invalid varint")
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated fixed64")
+			}
+			field.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, fmt.Errorf("truncated bytes")
+			}
+			field.data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unexpected wire type %v", key&7)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// unmarshalWriteRequest decodes a WriteRequest the way prompb would
+func unmarshalWriteRequest(b []byte) ([]TimeSeries, error) {
+	requestFields, err := parseProto(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []TimeSeries
+	for _, rf := range requestFields {
+		if rf.number != 1 {
+			return nil, fmt.Errorf("unexpected WriteRequest field %v", rf.number)
+		}
+
+		seriesFields, err := parseProto(rf.data)
+		if err != nil {
+			return nil, err
+		}
+
+		var ts TimeSeries
+		for _, sf := range seriesFields {
+			fields, err := parseProto(sf.data)
+			if err != nil {
+				return nil, err
+			}
+
+			switch sf.number {
+			case 1:
+				var label Label
+				for _, f := range fields {
+					if f.number == 1 {
+						label.Name = string(f.data)
+					} else if f.number == 2 {
+						label.Value = string(f.data)
+					}
+				}
+				ts.Labels = append(ts.Labels, label)
+			case 2:
+				var sample Sample
+				for _, f := range fields {
+					if f.number == 1 {
+						sample.Value = math.Float64frombits(f.varint)
+					} else if f.number == 2 {
+						sample.Timestamp = int64(f.varint)
+					}
+				}
+				ts.Samples = append(ts.Samples, sample)
+			}
+		}
+		series = append(series, ts)
+	}
+
+	return series, nil
+}
+
+func TestSnappyEncode(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		[]byte("abc"),
+		[]byte(strings.Repeat("t128_aggregate_session_node_bandwidth", 200)),
+		bytes.Repeat([]byte{0}, 100000),
+	}
+
+	for _, input := range inputs {
+		decoded, err := snappy.Decode(nil, snappyEncode(input))
+		if err != nil {
+			t.Errorf("snappy.Decode() of %v bytes = %v", len(input), err)
+			continue
+		}
+		if !bytes.Equal(decoded, input) {
+			t.Errorf("round trip of %v bytes produced %v different bytes", len(input), len(decoded))
+		}
+	}
+}
+
+// referenceSeries and referenceWriteRequest are a WriteRequest and its encoding by the
+// reference protobuf implementation, which leaves out zero values
+var referenceSeries = []TimeSeries{
+	{
+		Labels: []Label{{Name: "__name__", Value: "t128_cpu"}, {Name: "router", Value: "east"}},
+		Samples: []Sample{
+			{Value: 1.5, Timestamp: 1552576140000},
+			{Value: 0, Timestamp: 1552576200000},
+			{Value: -2, Timestamp: 0},
+		},
+	},
+	{
+		Labels:  []Label{{Name: "__name__", Value: "up"}, {Name: "empty", Value: ""}},
+		Samples: []Sample{{Value: 1, Timestamp: -1}},
+	},
+}
+
+var referenceWriteRequest = []byte{
+	0x0a, 0x4c, 0x0a, 0x14, 0x0a, 0x08, 0x5f, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x5f, 0x5f, 0x12, 0x08,
+	0x74, 0x31, 0x32, 0x38, 0x5f, 0x63, 0x70, 0x75, 0x0a, 0x0e, 0x0a, 0x06, 0x72, 0x6f, 0x75, 0x74,
+	0x65, 0x72, 0x12, 0x04, 0x65, 0x61, 0x73, 0x74, 0x12, 0x10, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0xf8, 0x3f, 0x10, 0xe0, 0xa5, 0xff, 0xe5, 0x97, 0x2d, 0x12, 0x07, 0x10, 0xc0, 0xfa, 0x82,
+	0xe6, 0x97, 0x2d, 0x12, 0x09, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0x0a, 0x2f,
+	0x0a, 0x0e, 0x0a, 0x08, 0x5f, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x5f, 0x5f, 0x12, 0x02, 0x75, 0x70,
+	0x0a, 0x07, 0x0a, 0x05, 0x65, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x14, 0x09, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0xf0, 0x3f, 0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+}
+
+func TestMarshalWriteRequest(t *testing.T) {
+	if got := marshalWriteRequest(referenceSeries); !bytes.Equal(got, referenceWriteRequest) {
+		t.Errorf("marshalWriteRequest() = %#v, want %#v", got, referenceWriteRequest)
+	}
+
+	// The receiver's decoding is only as good as its agreement with the reference
+	series, err := unmarshalWriteRequest(referenceWriteRequest)
+	if err != nil {
+		t.Fatalf("unmarshalWriteRequest() = %v", err)
+	}
+	if !reflect.DeepEqual(series, referenceSeries) {
+		t.Errorf("unmarshalWriteRequest() = %+v, want %+v", series, referenceSeries)
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	tests := []struct {
+		prefix, id, want string
+	}{
+		{"t128_", "aggregate-session/node/bandwidth", "t128_aggregate_session_node_bandwidth"},
+		{"", "/stats/cpu/utilization/", "stats_cpu_utilization"},
+		{"", "5g/signal", "_5g_signal"},
+	}
+
+	for _, test := range tests {
+		if got := MetricName(test.prefix, test.id); got != test.want {
+			t.Errorf("MetricName(%q, %q) = %q, want %q", test.prefix, test.id, got, test.want)
+		}
+	}
+}
+
+// receiver records the remote write requests it's sent, responding with the given
+// statuses in turn and then with 204
+type receiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	series   [][]TimeSeries
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := unmarshalWriteRequest(decoded)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.requests = append(r.requests, req)
+	r.series = append(r.series, series)
+
+	status := http.StatusNoContent
+	if len(r.statuses) != 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestClient(url string, retries int) *Client {
+	client := CreateClient(url, "t128_", retries, time.Second)
+	client.backoff = time.Millisecond
+	return client
+}
+
+func TestSend(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := newTestClient(server.URL+"/api/v1/write", 0)
+	client.Authenticate("", "", "token", "tenant-a")
+
+	err := client.Send("aggregate-session/node/bandwidth", map[string]string{
+		"router":    "east",
+		"node-name": "east-a",
+		"__secret":  "x",
+		"empty":     "",
+	}, []t128.AnalyticPoint{
+		{Value: 2.5, Time: "2019-03-14T15:10:00Z"},
+		{Value: 1, Time: "2019-03-14T15:09:00Z"},
+	})
+	if err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	if len(r.requests) != 1 {
+		t.Fatalf("receiver got %v requests, want 1", len(r.requests))
+	}
+
+	req := r.requests[0]
+	headers := map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"Authorization":                     "Bearer token",
+		"X-Scope-OrgID":                     "tenant-a",
+	}
+	for name, want := range headers {
+		if got := req.Header.Get(name); got != want {
+			t.Errorf("%v = %q, want %q", name, got, want)
+		}
+	}
+	if req.URL.Path != "/api/v1/write" {
+		t.Errorf("path = %v, want /api/v1/write", req.URL.Path)
+	}
+
+	want := []TimeSeries{{
+		Labels: []Label{
+			{Name: "__name__", Value: "t128_aggregate_session_node_bandwidth"},
+			{Name: "node_name", Value: "east-a"},
+			{Name: "router", Value: "east"},
+			{Name: "secret", Value: "x"},
+		},
+		Samples: []Sample{
+			{Value: 1, Timestamp: 1552576140000},
+			{Value: 2.5, Timestamp: 1552576200000},
+		},
+	}}
+	if !reflect.DeepEqual(r.series[0], want) {
+		t.Errorf("series = %+v, want %+v", r.series[0], want)
+	}
+}
+
+func TestSendWithoutPoints(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	if err := newTestClient(server.URL, 0).Send("metric", nil, nil); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if len(r.requests) != 0 {
+		t.Errorf("receiver got %v requests, want none", len(r.requests))
+	}
+}
+
+func TestWriteRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int
+		fails    bool
+	}{
+		{"unavailable", []int{503, 503}, 3, 3, false},
+		{"throttled", []int{429}, 3, 2, false},
+		{"exhausted", []int{500, 500, 500}, 2, 3, true},
+		{"bad request", []int{400}, 3, 1, true},
+		{"unauthorized", []int{401}, 3, 1, true},
+	}
+
+	for _, test := range tests {
+		r := &receiver{statuses: test.statuses}
+		server := httptest.NewServer(r)
+
+		err := newTestClient(server.URL, test.retries).Write([]TimeSeries{{
+			Labels:  []Label{{Name: "__name__", Value: "up"}},
+			Samples: []Sample{{Value: 1, Timestamp: 1}},
+		}})
+		server.Close()
+
+		if (err != nil) != test.fails {
+			t.Errorf("%v: Write() = %v, want failure %v", test.name, err, test.fails)
+		}
+		if len(r.requests) != test.requests {
+			t.Errorf("%v: receiver got %v requests, want %v", test.name, len(r.requests), test.requests)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package prometheus
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The types below mirror the messages of Prometheus' remote write protocol
+// (prompb.WriteRequest). They're encoded by hand to avoid a protobuf dependency.
+
+// Label represents a name/value pair identifying a time series
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample represents a single value of a time series at a time in milliseconds
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries represents the samples of a single labelled series
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(b, byte(v))
+}
+
+func appendKey(b []byte, field int, wireType int) []byte {
+	return appendVarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// Fields holding their zero value are left out, as proto3 encoders do
+
+func (l Label) marshal() []byte {
+	var b []byte
+	if l.Name != "" {
+		b = appendBytes(b, 1, []byte(l.Name))
+	}
+	if l.Value != "" {
+		b = appendBytes(b, 2, []byte(l.Value))
+	}
+	return b
+}
+
+func (s Sample) marshal() []byte {
+	var b []byte
+	if bits := math.Float64bits(s.Value); bits != 0 {
+		b = appendKey(b, 1, wireFixed64)
+		b = append(b, make([]byte, 8)...)
+		binary.LittleEndian.PutUint64(b[len(b)-8:], bits)
+	}
+	if s.Timestamp != 0 {
+		b = appendKey(b, 2, wireVarint)
+		b = appendVarint(b, uint64(s.Timestamp))
+	}
+	return b
+}
+
+func (ts TimeSeries) marshal() []byte {
+	var b []byte
+	for _, label := range ts.Labels {
+		b = appendBytes(b, 1, label.marshal())
+	}
+	for _, sample := range ts.Samples {
+		b = appendBytes(b, 2, sample.marshal())
+	}
+	return b
+}
+
+// marshalWriteRequest encodes a WriteRequest holding the given series
+func marshalWriteRequest(series []TimeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		b = appendBytes(b, 1, ts.marshal())
+	}
+	return b
+}
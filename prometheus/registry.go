@@ -0,0 +1,170 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	t128 "github.com/128technology/influx-importer/client"
+)
+
+// Registry holds the latest value of every series and serves them in the Prometheus
+// text exposition format for scraping
+type Registry struct {
+	prefix string
+
+	mutex    sync.RWMutex
+	families map[string]*family
+}
+
+type family struct {
+	help       string
+	metricType string
+	series     map[string]*series
+}
+
+type series struct {
+	labels  string
+	value   float64
+	updated time.Time
+}
+
+// NewRegistry creates an empty Registry. Every metric name is given the prefix.
+func NewRegistry(prefix string) *Registry {
+	return &Registry{
+		prefix:   prefix,
+		families: make(map[string]*family),
+	}
+}
+
+func (r *Registry) family(metricID string) *family {
+	name := MetricName(r.prefix, metricID)
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{metricType: "gauge", series: make(map[string]*series)}
+		r.families[name] = f
+	}
+
+	return f
+}
+
+// Describe sets the HELP text and TYPE of a metric from its descriptor
+func (r *Registry) Describe(descriptor *t128.MetricDescriptor) {
+	help := descriptor.Description
+	if descriptor.Units != "" {
+		help = fmt.Sprintf("%v (%v)", help, descriptor.Units)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f := r.family(descriptor.ID)
+	f.help = help
+	f.metricType = metricType(descriptor)
+}
+
+// metricType determines whether a metric is a counter or a gauge. Most 128T metrics are
+// aggregated over an interval, so only those described as running totals that aren't
+// measured as a rate are counters.
+func metricType(descriptor *t128.MetricDescriptor) string {
+	if strings.Contains(descriptor.Units, "/") {
+		return "gauge"
+	}
+
+	text := strings.ToLower(descriptor.ID + " " + descriptor.Description)
+	for _, word := range []string{"total", "cumulative"} {
+		if strings.Contains(text, word) {
+			return "counter"
+		}
+	}
+
+	return "gauge"
+}
+
+// Set records the latest value of a metric permutation. The tags become the series' labels.
+func (r *Registry) Set(metricID string, tags map[string]string, value float64) {
+	labels := formatLabels(sortedLabels(tags))
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.family(metricID).series[labels] = &series{labels: labels, value: value, updated: time.Now()}
+}
+
+// Expire removes series that haven't been set since the given time, e.g. those of a
+// router that has been removed
+func (r *Registry) Expire(before time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, f := range r.families {
+		for key, s := range f.series {
+			if s.updated.Before(before) {
+				delete(f.series, key)
+			}
+		}
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = fmt.Sprintf("%v=\"%v\"", label.Name, valueEscaper.Replace(label.Value))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// ServeHTTP writes every series in the text exposition format. Metrics that haven't
+// been described are written as gauges.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	for _, name := range names {
+		f := r.families[name]
+		if len(f.series) == 0 {
+			continue
+		}
+
+		if f.help != "" {
+			fmt.Fprintf(out, "# HELP %v %v\n", name, helpEscaper.Replace(f.help))
+		}
+		fmt.Fprintf(out, "# TYPE %v %v\n", name, f.metricType)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(out, "%v%v %v\n", name, key, strconv.FormatFloat(f.series[key].value, 'g', -1, 64))
+		}
+	}
+}
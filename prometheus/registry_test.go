@@ -0,0 +1,72 @@
+package prometheus
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	t128 "github.com/128technology/influx-importer/client"
+)
+
+func TestMetricType(t *testing.T) {
+	tests := []struct {
+		descriptor t128.MetricDescriptor
+		want       string
+	}{
+		{t128.MetricDescriptor{ID: "aggregate-session/node/bandwidth", Units: "bits/s"}, "gauge"},
+		{t128.MetricDescriptor{ID: "cpu/utilization", Description: "CPU utilization", Units: "percent"}, "gauge"},
+		{t128.MetricDescriptor{ID: "interface/received/total", Description: "Packets received", Units: "packets"}, "counter"},
+		{t128.MetricDescriptor{ID: "session/count", Description: "Cumulative sessions created", Units: "sessions"}, "counter"},
+		{t128.MetricDescriptor{ID: "interface/received/total-rate", Description: "Total packet rate", Units: "packets/s"}, "gauge"},
+	}
+
+	for _, test := range tests {
+		if got := metricType(&test.descriptor); got != test.want {
+			t.Errorf("metricType(%v) = %v, want %v", test.descriptor.ID, got, test.want)
+		}
+	}
+}
+
+func TestSortedLabels(t *testing.T) {
+	got := sortedLabels(map[string]string{
+		"node-name": "dashed",
+		"node_name": "underscored",
+		"node.name": "dotted",
+		"a.b":       "dotted",
+		"a-b":       "dashed",
+		"empty":     "",
+	})
+
+	want := []Label{
+		{Name: "a_b", Value: "dashed"},
+		{Name: "node_name", Value: "underscored"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortedLabels() = %+v, want %+v", got, want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	registry := NewRegistry("t128_")
+	registry.Describe(&t128.MetricDescriptor{ID: "bandwidth", Description: "Bandwidth", Units: "bits/s"})
+	registry.Describe(&t128.MetricDescriptor{ID: "packets/total", Description: "Packets", Units: "packets"})
+	registry.Set("bandwidth", map[string]string{"router": "east", "node": "a\"b"}, 1.5)
+	registry.Set("packets/total", map[string]string{"router": "east"}, 42)
+	registry.Set("undescribed", nil, 3)
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := `# HELP t128_bandwidth Bandwidth (bits/s)
+# TYPE t128_bandwidth gauge
+t128_bandwidth{node="a\"b",router="east"} 1.5
+# HELP t128_packets_total Packets (packets)
+# TYPE t128_packets_total counter
+t128_packets_total{router="east"} 42
+# TYPE t128_undescribed gauge
+t128_undescribed 3
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("ServeHTTP() wrote\n%v\nwant\n%v", got, want)
+	}
+}
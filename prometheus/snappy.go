@@ -0,0 +1,78 @@
+package prometheus
+
+import "encoding/binary"
+
+// snappyEncode compresses src in the snappy block format expected by remote write
+// receivers. It's a simple greedy encoder: repeated 4 byte sequences found through a
+// hash table become copies and everything else is written as literals.
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2+binary.MaxVarintLen64)
+	dst = appendVarint(dst, uint64(len(src)))
+
+	const tableBits = 14
+	var table [1 << tableBits]int
+
+	literalStart := 0
+	for i := 0; i+4 <= len(src); {
+		h := (binary.LittleEndian.Uint32(src[i:]) * 0x1e35a7bd) >> (32 - tableBits)
+
+		// positions are stored plus one so that zero means empty
+		candidate := table[h] - 1
+		table[h] = i + 1
+
+		if candidate < 0 || i-candidate > 0xffff ||
+			binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+
+		dst = emitLiteral(dst, src[literalStart:i])
+		dst = emitCopy(dst, i-candidate, length)
+		i += length
+		literalStart = i
+	}
+
+	return emitLiteral(dst, src[literalStart:])
+}
+
+func emitLiteral(dst []byte, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+
+	n := uint32(len(literal) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n<<2))
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+
+	return append(dst, literal...)
+}
+
+// emitCopy writes copies with 2 byte offsets, each covering at most 64 bytes
+func emitCopy(dst []byte, offset int, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > 64 {
+			n = 64
+		}
+
+		dst = append(dst, byte(n-1)<<2|2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+
+	return dst
+}